@@ -0,0 +1,233 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Kotodian/gokit/ac/lib"
+)
+
+// HeaderToken 携带共享密钥的请求头
+const HeaderToken = "X-Admin-Token"
+
+// maxFrameSize 注入报文请求体的最大长度, 超过时返回413
+const maxFrameSize = 64 << 10
+
+var (
+	ErrUnauthorized   = errors.New("unauthorized")
+	ErrClientNotFound = errors.New("client not found")
+	ErrInvalidCoreID  = errors.New("invalid coreId")
+	ErrNoRecorder     = errors.New("capture is not enabled")
+)
+
+// ClientInfo 客户端信息
+type ClientInfo struct {
+	lib.ClientStats
+	KeepAlive int64  `json:"keepalive"`
+	Coregw    string `json:"coregw"`
+}
+
+// Handler 网关的本地管理接口
+//
+//	GET  /clients                    列出所有的客户端
+//	GET  /stats                      网关的统计信息
+//	POST /clients/kick?coreId=|sn=   踢掉设备, 与MQTT的kick消息相同
+//	POST /clients/send?coreId=|sn=   向桩发送原始报文, format=hex时请求体为十六进制字符串
+//	GET  /capture                    正在抓包的sn以及文件
+//	POST /capture/start?sn=          开启桩的抓包, 需要设置Hub.Recorder
+//	POST /capture/stop?sn=           停止桩的抓包
+type Handler struct {
+	hub    *lib.Hub
+	secret string
+	mux    *http.ServeMux
+}
+
+// NewHandler secret为空时拒绝所有请求
+func NewHandler(hub *lib.Hub, secret string) *Handler {
+	h := &Handler{hub: hub, secret: secret, mux: http.NewServeMux()}
+	h.mux.HandleFunc("/clients", h.method(http.MethodGet, h.clients))
+	h.mux.HandleFunc("/stats", h.method(http.MethodGet, h.stats))
+	h.mux.HandleFunc("/clients/kick", h.method(http.MethodPost, h.kick))
+	h.mux.HandleFunc("/clients/send", h.method(http.MethodPost, h.send))
+	h.mux.HandleFunc("/capture", h.method(http.MethodGet, h.captures))
+	h.mux.HandleFunc("/capture/start", h.method(http.MethodPost, h.startCapture))
+	h.mux.HandleFunc("/capture/stop", h.method(http.MethodPost, h.stopCapture))
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get(HeaderToken)
+	if h.secret == "" || subtle.ConstantTimeCompare([]byte(token), []byte(h.secret)) != 1 {
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+	h.mux.ServeHTTP(w, r)
+}
+
+func (h *Handler) method(method string, f http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		f(w, r)
+	}
+}
+
+func (h *Handler) clients(w http.ResponseWriter, r *http.Request) {
+	infos := make([]ClientInfo, 0)
+	h.hub.Clients.Range(func(key, value interface{}) bool {
+		client := value.(lib.ClientInterface)
+		stats := lib.ClientStatsOf(client)
+		stats.Registered = true
+		infos = append(infos, ClientInfo{
+			ClientStats: stats,
+			KeepAlive:   client.KeepAlive(),
+			Coregw:      client.Coregw(),
+		})
+		return true
+	})
+	writeJSON(w, http.StatusOK, infos)
+}
+
+func (h *Handler) stats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.hub.Stats())
+}
+
+func (h *Handler) kick(w http.ResponseWriter, r *http.Request) {
+	client, err := h.find(r)
+	if err != nil {
+		writeError(w, findStatus(err), err)
+		return
+	}
+	if !h.hub.Kick(client.ChargeStation().CoreID()) {
+		writeError(w, http.StatusNotFound, ErrClientNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"msg": "ok"})
+}
+
+func (h *Handler) send(w http.ResponseWriter, r *http.Request) {
+	client, err := h.find(r)
+	if err != nil {
+		writeError(w, findStatus(err), err)
+		return
+	}
+	frame, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxFrameSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL.Query().Get("format") == "hex" {
+		if frame, err = hex.DecodeString(strings.TrimSpace(string(frame))); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+	if len(frame) == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("empty frame"))
+		return
+	}
+	if err = client.Send(frame); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"msg": "ok"})
+}
+
+func (h *Handler) captures(w http.ResponseWriter, r *http.Request) {
+	if h.hub.Recorder == nil {
+		writeError(w, http.StatusNotFound, ErrNoRecorder)
+		return
+	}
+	writeJSON(w, http.StatusOK, h.hub.Recorder.Sessions())
+}
+
+func (h *Handler) startCapture(w http.ResponseWriter, r *http.Request) {
+	sn := r.URL.Query().Get("sn")
+	if h.hub.Recorder == nil {
+		writeError(w, http.StatusNotFound, ErrNoRecorder)
+		return
+	}
+	if sn == "" {
+		writeError(w, http.StatusBadRequest, errors.New("sn is required"))
+		return
+	}
+	path, err := h.hub.Recorder.Enable(sn)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"msg": "ok", "file": path})
+}
+
+func (h *Handler) stopCapture(w http.ResponseWriter, r *http.Request) {
+	if h.hub.Recorder == nil {
+		writeError(w, http.StatusNotFound, ErrNoRecorder)
+		return
+	}
+	if err := h.hub.Recorder.Disable(r.URL.Query().Get("sn")); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"msg": "ok"})
+}
+
+// find 根据coreId或者sn查找已注册的客户端
+func (h *Handler) find(r *http.Request) (lib.ClientInterface, error) {
+	query := r.URL.Query()
+	if id := query.Get("coreId"); id != "" {
+		coreID, err := strconv.ParseUint(id, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidCoreID, id)
+		}
+		if c, ok := h.hub.Clients.Load(coreID); ok {
+			return c.(lib.ClientInterface), nil
+		}
+		return nil, ErrClientNotFound
+	}
+	sn := query.Get("sn")
+	var client lib.ClientInterface
+	h.hub.Clients.Range(func(key, value interface{}) bool {
+		c := value.(lib.ClientInterface)
+		if sn != "" && c.ChargeStation() != nil && c.ChargeStation().SN() == sn {
+			client = c
+			return false
+		}
+		return true
+	})
+	if client == nil {
+		return nil, ErrClientNotFound
+	}
+	return client, nil
+}
+
+// findStatus find失败时的状态码, coreId格式错误为400, 没有找到为404
+func findStatus(err error) int {
+	if errors.Is(err, ErrInvalidCoreID) {
+		return http.StatusBadRequest
+	}
+	return http.StatusNotFound
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"msg": err.Error()})
+}
@@ -0,0 +1,58 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandler(t *testing.T) {
+	hub := &lib.Hub{}
+	client := lib.NewTestClient()
+	hub.Clients.Store(client.ChargeStation().CoreID(), client)
+	handler := NewHandler(hub, "secret")
+
+	req := httptest.NewRequest(http.MethodGet, "/clients", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	req.Header.Set(HeaderToken, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var infos []ClientInfo
+	assert.Nil(t, json.NewDecoder(rec.Body).Decode(&infos))
+	assert.Len(t, infos, 1)
+	assert.Equal(t, "test", infos[0].SN)
+
+	req = httptest.NewRequest(http.MethodPost, "/clients/send?sn=test&format=hex", strings.NewReader("6802"))
+	req.Header.Set(HeaderToken, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/clients/kick?sn=unknown", nil)
+	req.Header.Set(HeaderToken, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	req = httptest.NewRequest(http.MethodPost, "/clients/kick?coreId=x", nil)
+	req.Header.Set(HeaderToken, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	// 请求体超过64KB时不截断
+	req = httptest.NewRequest(http.MethodPost, "/clients/send?sn=test", strings.NewReader(strings.Repeat("a", maxFrameSize+1)))
+	req.Header.Set(HeaderToken, "secret")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/api"
+	"github.com/Kotodian/gokit/datasource"
+	"github.com/Kotodian/protocol/interfaces"
+)
+
+// AccessVerifier 使用设备接入校验接口认证桩
+type AccessVerifier struct {
+	Tickets         api.TicketManager
+	Protocol        string
+	ProtocolVersion string
+	// Verify 设备接入校验, 默认为api.AccessVerify
+	Verify func(ticket string, request *api.AccessVerifyRequest) (*api.Equipment, error)
+}
+
+var _ lib.Authenticator = (*AccessVerifier)(nil)
+
+// NewAccessVerifier 使用hub的协议以及协议版本校验
+func NewAccessVerifier(tickets api.TicketManager, hub *lib.Hub) *AccessVerifier {
+	return &AccessVerifier{
+		Tickets:         tickets,
+		Protocol:        hub.Protocol,
+		ProtocolVersion: hub.ProtocolVersion,
+		Verify:          api.AccessVerify,
+	}
+}
+
+func (v *AccessVerifier) Authenticate(ctx context.Context, credentials *lib.Credentials) (*lib.Identity, error) {
+	ticket, err := v.Tickets.Get(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get ticket error, err:%s", err.Error())
+	}
+	verify := v.Verify
+	if verify == nil {
+		verify = api.AccessVerify
+	}
+	equipment, err := verify(ticket, &api.AccessVerifyRequest{
+		DeviceSerialNumber:    credentials.SN,
+		DeviceProtocol:        v.Protocol,
+		DeviceProtocolVersion: v.ProtocolVersion,
+		RequestPort:           credentials.Port,
+		RemoteAddress:         credentials.RemoteAddress,
+		CertSerialNumber:      credentials.CertificateSN,
+		Username:              credentials.Username,
+		Password:              credentials.Password,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", lib.ErrAuthentication, err.Error())
+	}
+	if equipment == nil {
+		return nil, fmt.Errorf("%w: %s not found", lib.ErrAuthentication, credentials.SN)
+	}
+	coreID, err := datasource.ParseUUID(equipment.CoreID)
+	if err != nil {
+		return nil, fmt.Errorf("parse core id %s error, err:%s", equipment.CoreID, err.Error())
+	}
+	return &lib.Identity{
+		ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, equipment.Registered, coreID.Uint64()),
+		KeepAlive:     int64(equipment.KeepAlive),
+		BaseURL:       equipment.BaseURL,
+		EncryptKey:    equipment.Key,
+	}, nil
+}
@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/api"
+	"github.com/stretchr/testify/assert"
+)
+
+type tickets string
+
+func (t tickets) Get(ctx context.Context) (string, error) {
+	return string(t), nil
+}
+
+func TestAccessVerifier(t *testing.T) {
+	hub := &lib.Hub{Protocol: "ocpp", ProtocolVersion: "1.6"}
+	verifier := NewAccessVerifier(tickets("ticket"), hub)
+	verifier.Verify = func(ticket string, request *api.AccessVerifyRequest) (*api.Equipment, error) {
+		assert.Equal(t, "ticket", ticket)
+		assert.Equal(t, "ocpp", request.DeviceProtocol)
+		if request.Password != "secret" {
+			return nil, errors.New("密码错误")
+		}
+		return &api.Equipment{KeepAlive: 60, CoreID: "42", Registered: true, BaseURL: "http://base"}, nil
+	}
+	hub.SetAuthenticator(verifier)
+
+	identity, err := hub.Authenticate(context.Background(), &lib.Credentials{SN: "sn1", Password: "secret", CertificateSN: "cert"})
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(42), identity.ChargeStation.CoreID())
+	assert.Equal(t, "sn1", identity.ChargeStation.SN())
+	assert.True(t, identity.ChargeStation.Registered())
+	assert.Equal(t, int64(60), identity.KeepAlive)
+	assert.Equal(t, "cert", identity.CertificateSN)
+
+	client := lib.NewTestClient()
+	identity.Apply(client)
+	assert.Equal(t, identity.ChargeStation, client.ChargeStation())
+
+	_, err = hub.Authenticate(context.Background(), &lib.Credentials{SN: "sn1", Password: "wrong"})
+	assert.True(t, errors.Is(err, lib.ErrAuthentication))
+	assert.Equal(t, uint64(1), hub.Stats().AuthFailures)
+}
@@ -0,0 +1,147 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+// Kind 记录的报文类型
+type Kind byte
+
+const (
+	// KindFrameIn 桩上传的原始报文(已解密)
+	KindFrameIn Kind = iota + 1
+	// KindFrameOut 发送给桩的报文(加密以及编码之前)
+	KindFrameOut
+	// KindMQTTIn 平台下发的MQTT消息, 内容为APDU
+	KindMQTTIn
+	// KindMQTTOut 发送到平台的MQTT消息, 内容为ToAPDU翻译后的APDU
+	KindMQTTOut
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindFrameIn:
+		return "frame-in"
+	case KindFrameOut:
+		return "frame-out"
+	case KindMQTTIn:
+		return "mqtt-in"
+	case KindMQTTOut:
+		return "mqtt-out"
+	}
+	return "unknown"
+}
+
+// magic 抓包文件的文件头
+const magic = "GKCAP1"
+
+// recordHeaderSize 时间(8) 类型(1) topic长度(2) 数据长度(4)
+const recordHeaderSize = 15
+
+// maxDataSize 单条记录数据的上限, 读取时超过的长度按照文件损坏处理
+const maxDataSize = 16 << 20
+
+var ErrInvalidFile = errors.New("invalid capture file")
+
+// Record 一条记录
+type Record struct {
+	Time  time.Time
+	Kind  Kind
+	Topic string // MQTT消息的topic, 报文为空
+	Data  []byte
+}
+
+// Writer 写入抓包文件, 不是并发安全的
+type Writer struct {
+	w           io.Writer
+	wroteHeader bool
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write 每条记录只调用一次底层的Write
+func (w *Writer) Write(r Record) error {
+	if len(r.Data) > maxDataSize {
+		return fmt.Errorf("record data too large: %d", len(r.Data))
+	}
+	// 记录头中topic的长度为2字节
+	if len(r.Topic) > math.MaxUint16 {
+		return fmt.Errorf("record topic too long: %d", len(r.Topic))
+	}
+	size := recordHeaderSize + len(r.Topic) + len(r.Data)
+	if !w.wroteHeader {
+		size += len(magic)
+	}
+	buf := make([]byte, 0, size)
+	if !w.wroteHeader {
+		buf = append(buf, magic...)
+	}
+	buf = binary.BigEndian.AppendUint64(buf, uint64(r.Time.UnixNano()))
+	buf = append(buf, byte(r.Kind))
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(r.Topic)))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(r.Data)))
+	buf = append(buf, r.Topic...)
+	buf = append(buf, r.Data...)
+	if _, err := w.w.Write(buf); err != nil {
+		return err
+	}
+	w.wroteHeader = true
+	return nil
+}
+
+// Reader 读取抓包文件
+type Reader struct {
+	r          io.Reader
+	readHeader bool
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Next 读取下一条记录, 读完时返回io.EOF, 文件末尾的记录不完整时返回io.ErrUnexpectedEOF
+func (r *Reader) Next() (Record, error) {
+	if !r.readHeader {
+		header := make([]byte, len(magic))
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return Record{}, ErrInvalidFile
+			}
+			return Record{}, err
+		}
+		if !bytes.Equal(header, []byte(magic)) {
+			return Record{}, ErrInvalidFile
+		}
+		r.readHeader = true
+	}
+	header := make([]byte, recordHeaderSize)
+	if _, err := io.ReadFull(r.r, header); err != nil {
+		return Record{}, err
+	}
+	topicSize := int(binary.BigEndian.Uint16(header[9:11]))
+	dataSize := int(binary.BigEndian.Uint32(header[11:15]))
+	if dataSize > maxDataSize {
+		return Record{}, ErrInvalidFile
+	}
+	body := make([]byte, topicSize+dataSize)
+	if _, err := io.ReadFull(r.r, body); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, err
+	}
+	return Record{
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(header[0:8]))),
+		Kind:  Kind(header[8]),
+		Topic: string(body[:topicSize]),
+		Data:  body[topicSize:],
+	}, nil
+}
@@ -0,0 +1,91 @@
+package capture
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterReader(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	now := time.Now()
+	records := []Record{
+		{Time: now, Kind: KindFrameIn, Data: []byte{0x68, 0x02, 0x01, 0x02}},
+		{Time: now.Add(time.Millisecond), Kind: KindMQTTOut, Topic: "coregw/host/command/1", Data: []byte("apdu")},
+	}
+	for _, record := range records {
+		assert.Nil(t, w.Write(record))
+	}
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	for _, expected := range records {
+		record, err := r.Next()
+		assert.Nil(t, err)
+		assert.Equal(t, expected.Kind, record.Kind)
+		assert.Equal(t, expected.Topic, record.Topic)
+		assert.Equal(t, expected.Data, record.Data)
+		assert.Equal(t, expected.Time.UnixNano(), record.Time.UnixNano())
+	}
+	_, err := r.Next()
+	assert.Equal(t, io.EOF, err)
+
+	// 最后一条记录写了一半
+	r = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]))
+	_, err = r.Next()
+	assert.Nil(t, err)
+	_, err = r.Next()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	_, err = NewReader(bytes.NewReader([]byte("invalid file"))).Next()
+	assert.Equal(t, ErrInvalidFile, err)
+
+	// 损坏的数据长度
+	corrupted := append([]byte(nil), buf.Bytes()...)
+	binary.BigEndian.PutUint32(corrupted[len(magic)+11:], 0xfffffff0)
+	_, err = NewReader(bytes.NewReader(corrupted)).Next()
+	assert.Equal(t, ErrInvalidFile, err)
+	assert.NotNil(t, w.Write(Record{Time: now, Kind: KindFrameIn, Data: make([]byte, maxDataSize+1)}))
+	assert.NotNil(t, w.Write(Record{Time: now, Kind: KindMQTTIn, Topic: strings.Repeat("a", math.MaxUint16+1)}))
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder(t.TempDir())
+	recorder.Record("sn1", KindFrameIn, "", []byte{0x01})
+	assert.False(t, recorder.Enabled("sn1"))
+
+	path, err := recorder.Enable("sn1")
+	assert.Nil(t, err)
+	again, err := recorder.Enable("sn1")
+	assert.Nil(t, err)
+	assert.Equal(t, path, again)
+	assert.Equal(t, map[string]string{"sn1": path}, recorder.Sessions())
+
+	recorder.Record("sn1", KindFrameIn, "", []byte{0x02})
+	recorder.Record("sn2", KindFrameIn, "", []byte{0x03})
+	recorder.Record("sn1", KindFrameOut, "", []byte{0x04})
+	assert.Nil(t, recorder.Close())
+	assert.False(t, recorder.Enabled("sn1"))
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+	r := NewReader(f)
+	var data []byte
+	for {
+		record, err := r.Next()
+		if err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		data = append(data, record.Data...)
+	}
+	assert.Equal(t, []byte{0x02, 0x04}, data)
+}
@@ -0,0 +1,115 @@
+package capture
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Recorder 按桩的sn开启抓包, 每个sn写入单独的文件
+//
+// 未开启的sn调用Record只有一次map查找的开销.
+type Recorder struct {
+	dir   string
+	mu    sync.Mutex
+	files sync.Map // sn -> *file
+}
+
+type file struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *Writer
+	path string
+}
+
+// NewRecorder 抓包文件保存在dir目录下
+func NewRecorder(dir string) *Recorder {
+	return &Recorder{dir: dir}
+}
+
+// Enable 开启sn的抓包, 返回抓包文件的路径, 已经开启时返回当前的文件
+func (r *Recorder) Enable(sn string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if v, ok := r.files.Load(sn); ok {
+		return v.(*file).path, nil
+	}
+	if err := os.MkdirAll(r.dir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.cap", sanitize(sn), time.Now().Format("20060102150405"))
+	path := filepath.Join(r.dir, name)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return "", err
+	}
+	r.files.Store(sn, &file{f: f, w: NewWriter(f), path: path})
+	return path, nil
+}
+
+// Disable 停止sn的抓包并关闭文件
+func (r *Recorder) Disable(sn string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	v, ok := r.files.LoadAndDelete(sn)
+	if !ok {
+		return nil
+	}
+	f := v.(*file)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.f.Close()
+}
+
+// Enabled sn是否开启了抓包
+func (r *Recorder) Enabled(sn string) bool {
+	_, ok := r.files.Load(sn)
+	return ok
+}
+
+// Sessions 正在抓包的sn以及对应的文件
+func (r *Recorder) Sessions() map[string]string {
+	sessions := make(map[string]string)
+	r.files.Range(func(key, value interface{}) bool {
+		sessions[key.(string)] = value.(*file).path
+		return true
+	})
+	return sessions
+}
+
+// Record 记录一条报文, sn未开启时直接返回
+func (r *Recorder) Record(sn string, kind Kind, topic string, data []byte) {
+	v, ok := r.files.Load(sn)
+	if !ok {
+		return
+	}
+	f := v.(*file)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_ = f.w.Write(Record{Time: time.Now(), Kind: kind, Topic: topic, Data: data})
+}
+
+// Close 停止所有的抓包
+func (r *Recorder) Close() error {
+	var err error
+	for sn := range r.Sessions() {
+		if e := r.Disable(sn); e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// sanitize 去掉sn中不能用作文件名的字符
+func sanitize(sn string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		return r
+	}, sn)
+}
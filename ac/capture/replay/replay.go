@@ -0,0 +1,85 @@
+// Package replay 将抓包文件重新交给协议翻译器处理, 用于离线复现协议问题
+package replay
+
+import (
+	"context"
+	"io"
+
+	"github.com/Kotodian/gokit/ac/capture"
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+)
+
+// Result 一条记录的翻译结果
+type Result struct {
+	Record capture.Record
+	// TRData 翻译时使用的上下文数据
+	TRData *lib.TRData
+	// APDU 桩上传的报文经ToAPDU翻译后的APDU, Payload已经编码
+	APDU *charger.APDU
+	// Frame 平台下发的消息经FromAPDU翻译后的结果
+	Frame interface{}
+	Err   error
+}
+
+// Replayer 只重放桩上传的报文(KindFrameIn)以及平台下发的消息(KindMQTTIn),
+// 其他类型的记录原样返回, 可以与翻译结果进行对比
+type Replayer struct {
+	TR     lib.ITranslate
+	Client lib.ClientInterface
+}
+
+// New client为空时使用lib.NewTestClient
+func New(tr lib.ITranslate, client lib.ClientInterface) *Replayer {
+	if client == nil {
+		client = lib.NewTestClient()
+	}
+	return &Replayer{TR: tr, Client: client}
+}
+
+// Replay 按顺序处理抓包文件中的记录, fn返回错误时停止
+func (r *Replayer) Replay(ctx context.Context, reader *capture.Reader, fn func(Result) error) error {
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		record, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(r.Translate(ctx, record)); err != nil {
+			return err
+		}
+	}
+}
+
+// Translate 翻译一条记录, 与tcp以及websocket客户端的处理流程一致
+func (r *Replayer) Translate(ctx context.Context, record capture.Record) Result {
+	result := Result{Record: record, TRData: &lib.TRData{}}
+	ctx = lib.NewMessageContext(ctx, r.Client, nil)
+	switch record.Kind {
+	case capture.KindFrameIn:
+		ctx = lib.WithTRData(ctx, result.TRData)
+		payload, err := r.TR.ToAPDU(ctx, record.Data)
+		if err != nil || payload == nil || result.TRData.APDU == nil {
+			result.Err = err
+			return result
+		}
+		result.APDU = result.TRData.APDU
+		result.APDU.Payload, result.Err = proto.Marshal(payload)
+	case capture.KindMQTTIn:
+		var apdu charger.APDU
+		if result.Err = proto.Unmarshal(record.Data, &apdu); result.Err != nil {
+			return result
+		}
+		result.TRData.APDU = &apdu
+		result.TRData.Topic = record.Topic
+		ctx = lib.WithTRData(ctx, result.TRData)
+		result.Frame, result.Err = r.TR.FromAPDU(ctx, &apdu)
+	}
+	return result
+}
@@ -0,0 +1,70 @@
+package replay
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/capture"
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+type translator struct{}
+
+func (translator) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	if len(msg) == 0 {
+		return nil, errors.New("empty frame")
+	}
+	trData, _ := lib.TRDataFromCtx(ctx)
+	trData.APDU = &charger.APDU{MessageId: charger.MessageID_ID_MessageError, SequenceId: uint64(msg[0])}
+	return &charger.MessageError{Description: string(msg[1:])}, nil
+}
+
+func (translator) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	trData, _ := lib.TRDataFromCtx(ctx)
+	return []byte(trData.Topic), nil
+}
+
+func TestReplay(t *testing.T) {
+	var buf bytes.Buffer
+	w := capture.NewWriter(&buf)
+	apdu, _ := proto.Marshal(&charger.APDU{SequenceId: 2})
+	for _, record := range []capture.Record{
+		{Kind: capture.KindFrameIn, Data: []byte("\x01boot")},
+		{Kind: capture.KindFrameIn},
+		{Kind: capture.KindMQTTIn, Topic: "host/command/1", Data: apdu},
+		{Kind: capture.KindFrameOut, Data: []byte("reply")},
+	} {
+		record.Time = time.Now()
+		assert.Nil(t, w.Write(record))
+	}
+
+	var results []Result
+	err := New(translator{}, nil).Replay(context.Background(), capture.NewReader(&buf), func(result Result) error {
+		results = append(results, result)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Len(t, results, 4)
+
+	assert.Nil(t, results[0].Err)
+	assert.Equal(t, uint64(1), results[0].APDU.SequenceId)
+	var payload charger.MessageError
+	assert.Nil(t, proto.Unmarshal(results[0].APDU.Payload, &payload))
+	assert.Equal(t, "boot", payload.Description)
+
+	assert.NotNil(t, results[1].Err)
+	assert.Nil(t, results[1].APDU)
+
+	assert.Nil(t, results[2].Err)
+	assert.Equal(t, []byte("host/command/1"), results[2].Frame)
+	assert.Equal(t, uint64(2), results[2].TRData.APDU.SequenceId)
+
+	assert.Equal(t, capture.KindFrameOut, results[3].Record.Kind)
+	assert.Nil(t, results[3].Err)
+}
@@ -0,0 +1,133 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/ac/tcp"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// issue 签发证书, parent为空时为自签名的CA
+func issue(t *testing.T, cn string, serial int64, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{cn},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}
+}
+
+func writePEM(t *testing.T, dir string, cert *x509.Certificate, key *ecdsa.PrivateKey) (string, string) {
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600))
+	der, err := x509.MarshalECPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	return certFile, keyFile
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, _ := issue(t, "ca", 1, nil, nil)
+	cert, key, _ := issue(t, "gateway", 2, ca, caKey)
+	certFile, keyFile := writePEM(t, dir, cert, key)
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Watch(10*time.Millisecond, stop, nil)
+
+	current, _ := reloader.GetCertificate(nil)
+	assert.Equal(t, cert.Raw, current.Certificate[0])
+
+	renewed, key, _ := issue(t, "gateway", 3, ca, caKey)
+	writePEM(t, dir, renewed, key)
+	// 保证修改时间比原来的文件新
+	later := time.Now().Add(time.Second)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	assert.Nil(t, os.Chtimes(keyFile, later, later))
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if current, _ = reloader.GetCertificate(nil); string(current.Certificate[0]) == string(renewed.Raw) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, renewed.Raw, current.Certificate[0])
+
+	_, err = NewReloader(filepath.Join(dir, "missing.pem"), keyFile)
+	assert.NotNil(t, err)
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, caKey, _ := issue(t, "ca", 1, nil, nil)
+	cert, key, _ := issue(t, "gateway", 2, ca, caKey)
+	certFile, keyFile := writePEM(t, dir, cert, key)
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "ca.pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+	pool, err := LoadCertPool(filepath.Join(dir, "ca.pem"))
+	assert.Nil(t, err)
+	reloader, err := NewReloader(certFile, keyFile)
+	assert.Nil(t, err)
+
+	codec := &tcp.DelimiterCodec{Delimiter: []byte("\n"), Strip: true}
+	server := tcp.NewServer(&lib.Hub{}, &rabbitmq.Logger{Logger: zap.NewNop()}, codec, codec)
+	server.TLSConfig = ServerConfig(reloader, pool)
+	certificateSN := make(chan string, 1)
+	server.OnConnect = func(client lib.ClientInterface) {
+		certificateSN <- client.CertificateSN()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	go server.Serve(l)
+	defer server.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	_, _, clientCert := issue(t, "CP001", 0xABCDEF, ca, caKey)
+	conn, err := tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "gateway", Certificates: []tls.Certificate{clientCert}})
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, "ABCDEF", <-certificateSN)
+
+	// 没有客户端证书时握手失败
+	conn, err = tls.Dial("tcp", l.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "gateway"})
+	if err == nil {
+		_, err = conn.Read(make([]byte, 1))
+		conn.Close()
+	}
+	assert.NotNil(t, err)
+	for server.Rejected() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,142 @@
+// Package cluster 网关集群模式使用的lib.Registry实现
+package cluster
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	rd "github.com/Kotodian/gokit/datasource/redis"
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultKeyPrefix 桩所在网关的key前缀
+const DefaultKeyPrefix = "gateway:owner:"
+
+var (
+	// refreshScript 仍由该网关负责时才延长过期时间
+	refreshScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+	// releaseScript 仍由该网关负责时才删除
+	releaseScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// RedisRegistry 在redis中记录桩所在的网关
+type RedisRegistry struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+var _ lib.Registry = (*RedisRegistry)(nil)
+
+// NewRedisRegistry pool为空时使用datasource/redis初始化的连接池, prefix为空时使用DefaultKeyPrefix
+func NewRedisRegistry(pool *redis.Pool, prefix string) *RedisRegistry {
+	if pool == nil {
+		pool = rd.Pool()
+	}
+	if prefix == "" {
+		prefix = DefaultKeyPrefix
+	}
+	return &RedisRegistry{pool: pool, prefix: prefix}
+}
+
+func (r *RedisRegistry) key(coreID uint64) string {
+	return r.prefix + strconv.FormatUint(coreID, 10)
+}
+
+func (r *RedisRegistry) Claim(coreID uint64, gateway string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", r.key(coreID), gateway, "PX", ttl.Milliseconds())
+	return err
+}
+
+func (r *RedisRegistry) Refresh(coreID uint64, gateway string, ttl time.Duration) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := refreshScript.Do(conn, r.key(coreID), gateway, ttl.Milliseconds())
+	return err
+}
+
+func (r *RedisRegistry) Release(coreID uint64, gateway string) error {
+	conn := r.pool.Get()
+	defer conn.Close()
+	_, err := releaseScript.Do(conn, r.key(coreID), gateway)
+	return err
+}
+
+func (r *RedisRegistry) Owner(coreID uint64) (string, error) {
+	conn := r.pool.Get()
+	defer conn.Close()
+	owner, err := redis.String(conn.Do("GET", r.key(coreID)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return owner, err
+}
+
+// MemoryRegistry 内存中的Registry, 用于测试以及单机部署
+type MemoryRegistry struct {
+	mu     sync.Mutex
+	owners map[uint64]owner
+}
+
+type owner struct {
+	gateway  string
+	expireAt time.Time
+}
+
+var _ lib.Registry = (*MemoryRegistry)(nil)
+
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{owners: make(map[uint64]owner)}
+}
+
+func (r *MemoryRegistry) Claim(coreID uint64, gateway string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.owners[coreID] = owner{gateway: gateway, expireAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (r *MemoryRegistry) Refresh(coreID uint64, gateway string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if o, ok := r.load(coreID); ok && o.gateway == gateway {
+		r.owners[coreID] = owner{gateway: gateway, expireAt: time.Now().Add(ttl)}
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Release(coreID uint64, gateway string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if o, ok := r.load(coreID); ok && o.gateway == gateway {
+		delete(r.owners, coreID)
+	}
+	return nil
+}
+
+func (r *MemoryRegistry) Owner(coreID uint64) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o, _ := r.load(coreID)
+	return o.gateway, nil
+}
+
+func (r *MemoryRegistry) load(coreID uint64) (owner, bool) {
+	o, ok := r.owners[coreID]
+	if ok && time.Now().After(o.expireAt) {
+		delete(r.owners, coreID)
+		return owner{}, false
+	}
+	return o, ok
+}
@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/ac/testkit"
+	"github.com/Kotodian/gokit/datasource"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryRegistry(t *testing.T) {
+	r := NewMemoryRegistry()
+	assert.Nil(t, r.Claim(1, "gw-a", time.Minute))
+	owner, _ := r.Owner(1)
+	assert.Equal(t, "gw-a", owner)
+
+	// 桩已经连接到其他网关, 原来的网关不能刷新以及删除
+	assert.Nil(t, r.Claim(1, "gw-b", time.Minute))
+	assert.Nil(t, r.Refresh(1, "gw-a", time.Millisecond))
+	assert.Nil(t, r.Release(1, "gw-a"))
+	owner, _ = r.Owner(1)
+	assert.Equal(t, "gw-b", owner)
+
+	assert.Nil(t, r.Refresh(1, "gw-b", time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	owner, _ = r.Owner(1)
+	assert.Equal(t, "", owner)
+}
+
+func newHub(t *testing.T, broker *testkit.Broker, registry lib.Registry, hostname string) *lib.Hub {
+	hub, err := lib.NewHubWithOptions("test", "1.0", lib.WithTransport(broker.Transport(hostname)), lib.WithHostname(hostname))
+	assert.Nil(t, err)
+	hub.SetCluster(&lib.Cluster{Registry: registry, Group: "ac"})
+	go hub.Run()
+	t.Cleanup(func() {
+		_ = hub.Shutdown(context.Background())
+	})
+	for !broker.Subscribed(hostname + "/kick/x") {
+		time.Sleep(time.Millisecond)
+	}
+	return hub
+}
+
+func TestForward(t *testing.T) {
+	broker := testkit.NewBroker()
+	registry := NewMemoryRegistry()
+	newHub(t, broker, registry, "gw-a")
+	hubB := newHub(t, broker, registry, "gw-b")
+
+	client := lib.NewTestClient()
+	coreID := client.ChargeStation().CoreID()
+	hubB.Clients.Store(coreID, client)
+	hubB.ClaimOwnership(client)
+	uuid := datasource.UUID(coreID).String()
+
+	// 发送到错误网关的消息转发给桩所在的网关
+	assert.Nil(t, broker.Publish("gw-a/command/"+uuid, []byte("command")))
+	m, err := broker.Expect("gw-b/command/"+uuid, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("command"), m.Payload)
+
+	// 共享订阅的消息轮流投递给两个网关, 其中一条由gw-a转发
+	assert.Nil(t, broker.Publish("ac/command/"+uuid, []byte("shared")))
+	assert.Nil(t, broker.Publish("ac/command/"+uuid, []byte("shared")))
+	m, err = broker.Expect("gw-b/command/"+uuid, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("shared"), m.Payload)
+
+	hubB.Clients.Delete(coreID)
+	hubB.ReleaseOwnership(client)
+	owner, _ := registry.Owner(coreID)
+	assert.Equal(t, "", owner)
+}
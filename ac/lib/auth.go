@@ -0,0 +1,134 @@
+package lib
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/Kotodian/protocol/interfaces"
+)
+
+// Credentials 桩接入时提供的认证信息
+type Credentials struct {
+	SN            string
+	Username      string
+	Password      string
+	RemoteAddress string
+	// Port 桩连接的网关端口
+	Port string
+	// CertificateSN 客户端证书的序列号, 没有使用证书时为空
+	CertificateSN string
+	// CommonName 客户端证书的CN, OCPP安全等级3中为桩的sn
+	CommonName string
+	// Subprotocol websocket协商的子协议
+	Subprotocol string
+}
+
+// SetPeerCertificate 使用TLS连接中已经验证的客户端证书填充CertificateSN和CommonName
+func (c *Credentials) SetPeerCertificate(state *tls.ConnectionState) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return
+	}
+	cert := state.PeerCertificates[0]
+	c.CertificateSN = CertificateSN(cert)
+	c.CommonName = cert.Subject.CommonName
+}
+
+// CertificateSN 证书序列号的大写十六进制形式
+func CertificateSN(cert *x509.Certificate) string {
+	return fmt.Sprintf("%X", cert.SerialNumber)
+}
+
+// Identity 认证通过后桩的信息, 用于初始化客户端
+type Identity struct {
+	ChargeStation interfaces.ChargeStation
+	// KeepAlive 心跳时间, 为0时使用创建客户端时的值
+	KeepAlive     int64
+	BaseURL       string
+	EncryptKey    string
+	CertificateSN string
+}
+
+// Apply 把认证的结果设置到客户端
+func (i *Identity) Apply(client ClientInterface) {
+	client.SetChargeStation(i.ChargeStation)
+	if i.KeepAlive > 0 {
+		client.SetKeepalive(i.KeepAlive)
+	}
+	if i.BaseURL != "" {
+		client.SetBaseURL(i.BaseURL)
+	}
+	if i.EncryptKey != "" {
+		client.SetEncryptKey(i.EncryptKey)
+	}
+	if i.CertificateSN != "" {
+		client.SetCertificateSN(i.CertificateSN)
+	}
+}
+
+// Authenticator 桩建立连接时认证, 返回错误时拒绝连接
+type Authenticator interface {
+	Authenticate(ctx context.Context, credentials *Credentials) (*Identity, error)
+}
+
+// AuthenticatorFunc 函数形式的Authenticator
+type AuthenticatorFunc func(ctx context.Context, credentials *Credentials) (*Identity, error)
+
+func (f AuthenticatorFunc) Authenticate(ctx context.Context, credentials *Credentials) (*Identity, error) {
+	return f(ctx, credentials)
+}
+
+// ErrAuthentication 桩认证失败
+var ErrAuthentication = errors.New("桩认证失败")
+
+// ErrNoAuthenticator hub没有设置Authenticator
+var ErrNoAuthenticator = errors.New("no authenticator")
+
+func (h *Hub) SetAuthenticator(authenticator Authenticator) {
+	h.Authenticator = authenticator
+}
+
+// Authenticate 使用Authenticator认证桩, 失败时返回的错误都包含ErrAuthentication, 并记录失败次数
+func (h *Hub) Authenticate(ctx context.Context, credentials *Credentials) (*Identity, error) {
+	identity, err := h.authenticate(ctx, credentials)
+	if err != nil {
+		h.AddAuthFailure()
+		if !errors.Is(err, ErrAuthentication) {
+			err = fmt.Errorf("%w: %s", ErrAuthentication, err.Error())
+		}
+		return nil, err
+	}
+	if identity.CertificateSN == "" {
+		identity.CertificateSN = credentials.CertificateSN
+	}
+	return identity, nil
+}
+
+// AddAuthFailure 记录一次认证失败, 认证之前就拒绝连接时调用
+func (h *Hub) AddAuthFailure() {
+	atomic.AddUint64(&h.authFailures, 1)
+}
+
+func (h *Hub) authenticate(ctx context.Context, credentials *Credentials) (*Identity, error) {
+	if h.Authenticator == nil {
+		return nil, ErrNoAuthenticator
+	}
+	if h.IsClosing() {
+		return nil, ErrShutdown
+	}
+	// 使用证书认证时CN必须是桩的sn, 防止使用其他桩的证书
+	if credentials.CommonName != "" && credentials.CommonName != credentials.SN {
+		return nil, fmt.Errorf("certificate %s does not belong to %s", credentials.CommonName, credentials.SN)
+	}
+	identity, err := h.Authenticator.Authenticate(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	if identity == nil || identity.ChargeStation == nil {
+		return nil, fmt.Errorf("no charge station for %s", credentials.SN)
+	}
+	return identity, nil
+}
@@ -0,0 +1,30 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Kotodian/protocol/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthenticateCertificate(t *testing.T) {
+	hub := &Hub{}
+	hub.SetAuthenticator(AuthenticatorFunc(func(ctx context.Context, credentials *Credentials) (*Identity, error) {
+		return &Identity{ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, true, 1)}, nil
+	}))
+
+	identity, err := hub.Authenticate(context.Background(), &Credentials{SN: "CP001", CommonName: "CP001", CertificateSN: "ABCDEF"})
+	assert.Nil(t, err)
+	assert.Equal(t, "ABCDEF", identity.CertificateSN)
+
+	// 其他桩的证书
+	_, err = hub.Authenticate(context.Background(), &Credentials{SN: "CP002", CommonName: "CP001", CertificateSN: "ABCDEF"})
+	assert.True(t, errors.Is(err, ErrAuthentication))
+	assert.Equal(t, uint64(1), hub.Stats().AuthFailures)
+
+	// 没有使用证书
+	_, err = hub.Authenticate(context.Background(), &Credentials{SN: "CP002"})
+	assert.Nil(t, err)
+}
@@ -2,11 +2,13 @@ package lib
 
 import (
 	"crypto/md5"
-	"fmt"
+	"crypto/sha256"
+	"hash"
 	"io"
 	"os"
 )
 
+// Check 校验码, 实现有NewCRC16, NewCRC32, NewSum8以及NewXOR8
 type Check interface {
 	// Generate 生成校验码
 	Generate([]byte) []byte
@@ -46,6 +48,7 @@ var MbTable = []uint16{
 	0x4400, 0x84C1, 0x8581, 0x4540, 0x8701, 0x47C0, 0x4680, 0x8641,
 	0x8201, 0x42C0, 0x4380, 0x8341, 0x4100, 0x81C1, 0x8081, 0x4040}
 
+// CheckSum Modbus的CRC16, 小端, 与NewCRC16(CRC16Modbus, binary.LittleEndian)相同
 func CheckSum(data []byte) []byte {
 	var crc16 uint16
 	crc16 = 0xffff
@@ -62,31 +65,39 @@ func MD5(data []byte) [16]byte {
 	return md5.Sum(data)
 }
 
-const filechunk = 1024 // 1KB
+const filechunk = 32 * 1024
 
-func MD5Chunck(path string) {
-	file, err := os.Open(path)
+// HashReader 读取r直到EOF, 返回h的摘要
+func HashReader(r io.Reader, h hash.Hash) ([]byte, error) {
+	buf := make([]byte, filechunk)
+	if _, err := io.CopyBuffer(h, r, buf); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
 
+// HashFile 分块读取文件计算摘要, 用于固件等较大的文件
+func HashFile(path string, h hash.Hash) ([]byte, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		panic(err.Error())
+		return nil, err
 	}
-
 	defer file.Close()
+	return HashReader(file, h)
+}
 
-	// calculate the file size
-	// info, _ := file.Stat()
-
-	// filesize := info.Size()
-
-	// blocks := uint64(math.Ceil(float64(filesize) / float64(filechunk)))
+// MD5File 文件的MD5
+func MD5File(path string) ([]byte, error) {
+	return HashFile(path, md5.New())
+}
 
-	// for i := uint64(0); i < blocks; i++ {
-	hash := md5.New()
-	// blocksize := int(math.Min(filechunk, float64(filesize-int64(i*filechunk))))
-	buf := make([]byte, filechunk)
-	file.Read(buf)
-	io.WriteString(hash, string(buf)) // append into the hash
-	fmt.Printf("length: %d, block checksum is %x\n", len(buf), hash.Sum(nil))
-	// }
+// SHA256File 文件的SHA256
+func SHA256File(path string) ([]byte, error) {
+	return HashFile(path, sha256.New())
+}
 
+// MD5Chunck 文件的MD5
+// Deprecated: 使用MD5File
+func MD5Chunck(path string) ([]byte, error) {
+	return MD5File(path)
 }
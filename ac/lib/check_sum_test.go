@@ -1,8 +1,16 @@
 package lib
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestCRC(t *testing.T) {
@@ -64,5 +72,48 @@ func TestMD5(t *testing.T) {
 }
 
 func TestMD5Chunck(t *testing.T) {
-	MD5Chunck("remote_TOF22000003_0.0.10.bin")
+	// 超过一个分块的文件
+	data := bytes.Repeat([]byte("0123456789ABCDEF"), filechunk/8+1)
+	path := filepath.Join(t.TempDir(), "remote_TOF22000003_0.0.10.bin")
+	assert.Nil(t, os.WriteFile(path, data, 0644))
+
+	sum, err := MD5Chunck(path)
+	assert.Nil(t, err)
+	expected := MD5(data)
+	assert.Equal(t, expected[:], sum)
+
+	sum, err = SHA256File(path)
+	assert.Nil(t, err)
+	expectedSHA256 := sha256.Sum256(data)
+	assert.Equal(t, expectedSHA256[:], sum)
+
+	_, err = MD5File(filepath.Join(t.TempDir(), "not_exist.bin"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheck(t *testing.T) {
+	data := []byte("123456789")
+	for _, c := range []struct {
+		check    Check
+		expected []byte
+	}{
+		{NewCRC16(CRC16Modbus, binary.LittleEndian), []byte{0x37, 0x4B}},
+		{NewCRC16(CRC16Modbus, nil), []byte{0x4B, 0x37}},
+		{NewCRC16(CRC16CCITTFalse, nil), []byte{0x29, 0xB1}},
+		{NewCRC16(CRC16XModem, nil), []byte{0x31, 0xC3}},
+		// CRC-16/X-25
+		{NewCRC16(CRC16Params{Poly: 0x1021, Init: 0xFFFF, RefIn: true, RefOut: true, XorOut: 0xFFFF}, nil), []byte{0x90, 0x6E}},
+		{NewCRC32(nil, nil), []byte{0xCB, 0xF4, 0x39, 0x26}},
+		{NewCRC32(crc32.MakeTable(crc32.Castagnoli), binary.LittleEndian), []byte{0x83, 0x92, 0x06, 0xE3}},
+		{NewSum8(), []byte{0xDD}},
+		{NewXOR8(), []byte{0x31}},
+	} {
+		assert.Equal(t, c.expected, c.check.Generate(data))
+		assert.True(t, VerifyCheck(c.check, data, c.expected))
+	}
+
+	frame := []byte{
+		0x68, 0x01, 0x00, 0xC0, 0x07, 0x00, 0x01, 0x00, 0x02, 0x00, 0x14, 0x02, 0xD3, 0x0D, 0x24, 0x46, 0x50,
+	}
+	assert.Equal(t, CheckSum(frame), NewCRC16(CRC16Modbus, binary.LittleEndian).Generate(frame))
 }
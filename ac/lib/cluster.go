@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	"go.uber.org/zap"
+)
+
+// Registry 记录桩连接在哪个网关上
+type Registry interface {
+	// Claim 记录桩连接在gateway上, ttl后过期
+	Claim(coreID uint64, gateway string, ttl time.Duration) error
+	// Refresh 桩仍然连接在gateway上时延长过期时间
+	Refresh(coreID uint64, gateway string, ttl time.Duration) error
+	// Release 桩仍然连接在gateway上时删除记录
+	Release(coreID uint64, gateway string) error
+	// Owner 桩所在的网关, 没有记录时返回空字符串
+	Owner(coreID uint64) (string, error)
+}
+
+// DefaultOwnerTTL 与桩在线状态的过期时间一致, 由心跳刷新
+const DefaultOwnerTTL = 190 * time.Second
+
+const (
+	// forwardQueueSize 等待转发到其他网关的消息数量上限, 超过时丢弃
+	forwardQueueSize = 1000
+	// forwardWorkers 转发消息的goroutine数量
+	forwardWorkers = 4
+)
+
+type forwardMessage struct {
+	m      mqtt.MqttMessage
+	coreID uint64
+}
+
+// Cluster 集群模式的配置
+//
+// 开启后网关在Registry中记录桩所在的网关, 收到不在本网关的桩的消息时转发给所在的网关.
+// 平台可以把消息发送到"<Group>/command/..."等与网关无关的topic, 由其中一个网关通过共享订阅接收.
+type Cluster struct {
+	Registry Registry
+	// Group 共享订阅的分组以及与网关无关的topic前缀, 所有网关应该相同
+	Group string
+	// TTL 桩与网关对应关系的过期时间, 为0时使用DefaultOwnerTTL
+	TTL time.Duration
+}
+
+func (h *Hub) SetCluster(cluster *Cluster) {
+	h.Cluster = cluster
+}
+
+func (c *Cluster) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultOwnerTTL
+}
+
+// ClaimOwnership 集群模式下记录桩连接在本网关, 桩注册完成后调用
+func (h *Hub) ClaimOwnership(client ClientInterface) {
+	if h.Cluster == nil || client.ChargeStation() == nil {
+		return
+	}
+	coreID := client.ChargeStation().CoreID()
+	if err := h.Cluster.Registry.Claim(coreID, h.Hostname, h.Cluster.ttl()); err != nil {
+		h.logger().Error("claim charging station error, err:"+err.Error(), zap.Uint64("coreId", coreID))
+	}
+}
+
+// RefreshOwnership 集群模式下延长桩与本网关对应关系的过期时间, 收到桩的心跳时调用
+func (h *Hub) RefreshOwnership(client ClientInterface) {
+	if h.Cluster == nil || client.ChargeStation() == nil {
+		return
+	}
+	coreID := client.ChargeStation().CoreID()
+	if err := h.Cluster.Registry.Refresh(coreID, h.Hostname, h.Cluster.ttl()); err != nil {
+		h.logger().Error("refresh charging station error, err:"+err.Error(), zap.Uint64("coreId", coreID))
+	}
+}
+
+// ReleaseOwnership 集群模式下删除桩与本网关的对应关系, 桩重新连接到本网关时不删除
+func (h *Hub) ReleaseOwnership(client ClientInterface) {
+	if h.Cluster == nil || client.ChargeStation() == nil {
+		return
+	}
+	coreID := client.ChargeStation().CoreID()
+	if c, ok := h.Clients.Load(coreID); ok && c != client {
+		return
+	}
+	if err := h.Cluster.Registry.Release(coreID, h.Hostname); err != nil {
+		h.logger().Error("release charging station error, err:"+err.Error(), zap.Uint64("coreId", coreID))
+	}
+}
+
+// forward 集群模式下把不在本网关的桩的消息放入转发队列, 没有开启集群模式时返回false
+// 在MQTT的回调中调用, 不能阻塞, 队列已满时丢弃
+func (h *Hub) forward(m mqtt.MqttMessage, coreID uint64) bool {
+	if h.Cluster == nil || h.forwards == nil {
+		return false
+	}
+	select {
+	case h.forwards <- forwardMessage{m: m, coreID: coreID}:
+	default:
+		h.logger().Error("forward queue is full, drop message", zap.Uint64("coreId", coreID), zap.String("topic", m.Topic))
+	}
+	return true
+}
+
+// runForward 处理转发队列直到ctx结束
+func (h *Hub) runForward(ctx context.Context) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case f := <-h.forwards:
+			h.forwardToOwner(ctx, f.m, f.coreID)
+		}
+	}
+}
+
+// forwardToOwner 查询桩所在的网关并转发, 桩不在其他网关上时丢弃
+func (h *Hub) forwardToOwner(ctx context.Context, m mqtt.MqttMessage, coreID uint64) {
+	owner, err := h.Cluster.Registry.Owner(coreID)
+	if err != nil {
+		h.logger().Error("get owner of charging station error, err:"+err.Error(), zap.Uint64("coreId", coreID))
+		return
+	}
+	if owner == "" || owner == h.Hostname {
+		h.logger().Info("charging station offline", zap.Uint64("coreId", coreID), zap.String("topic", m.Topic))
+		return
+	}
+	m.Topic = replaceTopicPrefix(m.Topic, owner)
+	select {
+	case h.PubMqttMsg <- m:
+	case <-ctx.Done():
+	}
+}
+
+// subscribeShared 集群模式下通过共享订阅接收"<Group>/<name>/#"的消息,
+// 收到的topic前缀替换为本网关的名称后交给handler处理
+func (h *Hub) subscribeShared(handler func(m mqtt.MqttMessage), names ...string) error {
+	filters := h.sharedFilters(names...)
+	if len(filters) == 0 {
+		return nil
+	}
+	return h.Transport.Subscribe(filters, func(m mqtt.MqttMessage) {
+		m.Topic = replaceTopicPrefix(m.Topic, h.Hostname)
+		handler(m)
+	})
+}
+
+func (h *Hub) sharedFilters(names ...string) map[string]byte {
+	if h.Cluster == nil {
+		return nil
+	}
+	filters := make(map[string]byte, len(names))
+	for _, name := range names {
+		filters[mqtt.SharePrefix()+h.Cluster.Group+"/"+h.Cluster.Group+"/"+name+"/#"] = 2
+	}
+	return filters
+}
+
+// replaceTopicPrefix 替换topic的第一级
+func replaceTopicPrefix(topic, prefix string) string {
+	if i := strings.Index(topic, "/"); i >= 0 {
+		return prefix + topic[i:]
+	}
+	return prefix
+}
@@ -1,15 +1,109 @@
 package lib
 
-import "context"
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 
-func GetClientFromCtx(ctx context.Context) ClientInterface {
-	return ctx.Value("client").(ClientInterface)
+	"go.uber.org/zap"
+)
+
+// ctxKey 上下文的key, 不导出以免与其他包冲突
+type ctxKey int
+
+const (
+	clientKey ctxKey = iota
+	trDataKey
+	requestIDKey
+	loggerKey
+)
+
+// WithClient 把客户端放入上下文
+func WithClient(ctx context.Context, client ClientInterface) context.Context {
+	return context.WithValue(ctx, clientKey, client)
 }
 
-func GetTRDataFromCtx(ctx context.Context) *TRData {
-	data := ctx.Value("trData").(*TRData)
+// ClientFromCtx 上下文中的客户端
+func ClientFromCtx(ctx context.Context) (ClientInterface, bool) {
+	client, ok := ctx.Value(clientKey).(ClientInterface)
+	return client, ok
+}
+
+// WithTRData 把协议翻译的数据放入上下文
+func WithTRData(ctx context.Context, trData *TRData) context.Context {
+	return context.WithValue(ctx, trDataKey, trData)
+}
+
+// TRDataFromCtx 上下文中协议翻译的数据, Data为空时会初始化
+func TRDataFromCtx(ctx context.Context) (*TRData, bool) {
+	data, ok := ctx.Value(trDataKey).(*TRData)
+	if !ok || data == nil {
+		return nil, false
+	}
 	if data.Data == nil {
 		data.Data = make(map[string]interface{})
 	}
-	return data
+	return data, true
+}
+
+// WithRequestID 把报文的请求id放入上下文, 用于关联同一条报文的日志
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey, requestID)
+}
+
+// RequestIDFromCtx 上下文中的请求id
+func RequestIDFromCtx(ctx context.Context) (string, bool) {
+	requestID, ok := ctx.Value(requestIDKey).(string)
+	return requestID, ok
+}
+
+// WithLogger 把日志放入上下文
+func WithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// LoggerFromCtx 上下文中的日志
+func LoggerFromCtx(ctx context.Context) (*zap.Logger, bool) {
+	logger, ok := ctx.Value(loggerKey).(*zap.Logger)
+	return logger, ok && logger != nil
+}
+
+// NewMessageContext 处理一条报文的上下文, 包含客户端, 新的请求id,
+// 以及带有sn, coreId和requestId字段的日志, logger为空时不放入日志
+func NewMessageContext(ctx context.Context, client ClientInterface, logger *zap.Logger) context.Context {
+	requestID := newRequestID()
+	ctx = WithRequestID(WithClient(ctx, client), requestID)
+	if logger == nil {
+		return ctx
+	}
+	fields := []zap.Field{zap.String("requestId", requestID)}
+	if chargeStation := client.ChargeStation(); chargeStation != nil {
+		fields = append(fields, zap.String("sn", chargeStation.SN()), zap.Uint64("coreId", chargeStation.CoreID()))
+	}
+	return WithLogger(ctx, logger.With(fields...))
+}
+
+// newRequestID 随机的请求id, id包需要先调用Init, 这里不依赖它
+func newRequestID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// GetClientFromCtx 上下文中的客户端, 没有时返回nil
+//
+// Deprecated: 使用ClientFromCtx
+func GetClientFromCtx(ctx context.Context) ClientInterface {
+	client, _ := ClientFromCtx(ctx)
+	return client
+}
+
+// GetTRDataFromCtx 上下文中协议翻译的数据, 没有时返回新的TRData, 对它的修改不会生效
+//
+// Deprecated: 使用TRDataFromCtx
+func GetTRDataFromCtx(ctx context.Context) *TRData {
+	if data, ok := TRDataFromCtx(ctx); ok {
+		return data
+	}
+	return &TRData{Data: make(map[string]interface{})}
 }
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestContext(t *testing.T) {
+	ctx := context.Background()
+	_, ok := ClientFromCtx(ctx)
+	assert.False(t, ok)
+	_, ok = TRDataFromCtx(ctx)
+	assert.False(t, ok)
+	// 没有放入数据时旧的函数不会panic
+	assert.Nil(t, GetClientFromCtx(ctx))
+	assert.NotNil(t, GetTRDataFromCtx(ctx).Data)
+	// 与字符串key不冲突
+	ctx = context.WithValue(ctx, "client", "x")
+	_, ok = ClientFromCtx(ctx)
+	assert.False(t, ok)
+
+	core, logs := observer.New(zap.InfoLevel)
+	client := NewTestClient()
+	ctx = WithTRData(NewMessageContext(ctx, client, zap.New(core)), &TRData{})
+	c, ok := ClientFromCtx(ctx)
+	assert.True(t, ok)
+	assert.Equal(t, client, c)
+	trData, ok := TRDataFromCtx(ctx)
+	assert.True(t, ok)
+	assert.NotNil(t, trData.Data)
+	requestID, ok := RequestIDFromCtx(ctx)
+	assert.True(t, ok)
+	assert.NotEmpty(t, requestID)
+
+	logger, ok := LoggerFromCtx(ctx)
+	assert.True(t, ok)
+	logger.Info("message")
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, requestID, fields["requestId"])
+	assert.Equal(t, "test", fields["sn"])
+	assert.Equal(t, uint64(0), fields["coreId"])
+
+	next, _ := RequestIDFromCtx(NewMessageContext(ctx, client, nil))
+	assert.NotEqual(t, requestID, next)
+}
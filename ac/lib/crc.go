@@ -0,0 +1,157 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// CRC16Params CRC16的参数, 与常用的CRC参数表(例如reveng)的命名一致
+type CRC16Params struct {
+	Poly   uint16
+	Init   uint16
+	RefIn  bool
+	RefOut bool
+	XorOut uint16
+}
+
+var (
+	// CRC16Modbus 与CheckSum相同
+	CRC16Modbus = CRC16Params{Poly: 0x8005, Init: 0xFFFF, RefIn: true, RefOut: true}
+	// CRC16CCITTFalse 又称CRC-16/IBM-3740
+	CRC16CCITTFalse = CRC16Params{Poly: 0x1021, Init: 0xFFFF}
+	// CRC16XModem 又称CRC-16/ACORN
+	CRC16XModem = CRC16Params{Poly: 0x1021}
+)
+
+type crc16 struct {
+	params CRC16Params
+	order  binary.ByteOrder
+	table  [256]uint16
+}
+
+// NewCRC16 order为校验码的字节序, 为nil时为大端, Modbus一般为小端
+func NewCRC16(params CRC16Params, order binary.ByteOrder) Check {
+	if order == nil {
+		order = binary.BigEndian
+	}
+	c := &crc16{params: params, order: order}
+	for i := range c.table {
+		if params.RefIn {
+			// 输入反转时使用反转的多项式计算
+			poly := reverse16(params.Poly)
+			crc := uint16(i)
+			for j := 0; j < 8; j++ {
+				if crc&1 == 1 {
+					crc = crc>>1 ^ poly
+				} else {
+					crc >>= 1
+				}
+			}
+			c.table[i] = crc
+		} else {
+			crc := uint16(i) << 8
+			for j := 0; j < 8; j++ {
+				if crc&0x8000 != 0 {
+					crc = crc<<1 ^ params.Poly
+				} else {
+					crc <<= 1
+				}
+			}
+			c.table[i] = crc
+		}
+	}
+	return c
+}
+
+// sum16 返回数值形式的校验码
+func (c *crc16) sum16(data []byte) uint16 {
+	crc := c.params.Init
+	if c.params.RefIn {
+		crc = reverse16(crc)
+		for _, v := range data {
+			crc = crc>>8 ^ c.table[byte(crc)^v]
+		}
+	} else {
+		for _, v := range data {
+			crc = crc<<8 ^ c.table[byte(crc>>8)^v]
+		}
+	}
+	// 计算时的反转状态与RefIn一致, 与RefOut不同时再反转一次
+	if c.params.RefIn != c.params.RefOut {
+		crc = reverse16(crc)
+	}
+	return crc ^ c.params.XorOut
+}
+
+func (c *crc16) Generate(data []byte) []byte {
+	sum := make([]byte, 2)
+	c.order.PutUint16(sum, c.sum16(data))
+	return sum
+}
+
+func reverse16(v uint16) uint16 {
+	var r uint16
+	for i := 0; i < 16; i++ {
+		r = r<<1 | v&1
+		v >>= 1
+	}
+	return r
+}
+
+type crc32Check struct {
+	table *crc32.Table
+	order binary.ByteOrder
+}
+
+// NewCRC32 table为nil时为IEEE(以太网, zip), order为nil时为大端
+func NewCRC32(table *crc32.Table, order binary.ByteOrder) Check {
+	if table == nil {
+		table = crc32.IEEETable
+	}
+	if order == nil {
+		order = binary.BigEndian
+	}
+	return &crc32Check{table: table, order: order}
+}
+
+func (c *crc32Check) Generate(data []byte) []byte {
+	sum := make([]byte, 4)
+	c.order.PutUint32(sum, crc32.Checksum(data, c.table))
+	return sum
+}
+
+type sum8 struct{}
+
+// NewSum8 所有字节相加, 取最低的一个字节
+func NewSum8() Check {
+	return sum8{}
+}
+
+func (sum8) Generate(data []byte) []byte {
+	var sum byte
+	for _, v := range data {
+		sum += v
+	}
+	return []byte{sum}
+}
+
+type xor8 struct{}
+
+// NewXOR8 所有字节异或(BCC)
+func NewXOR8() Check {
+	return xor8{}
+}
+
+func (xor8) Generate(data []byte) []byte {
+	var sum byte
+	for _, v := range data {
+		sum ^= v
+	}
+	return []byte{sum}
+}
+
+// VerifyCheck 校验data的校验码是否为sum
+func VerifyCheck(check Check, data []byte, sum []byte) bool {
+	return bytes.Equal(check.Generate(data), sum)
+}
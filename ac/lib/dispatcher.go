@@ -0,0 +1,63 @@
+package lib
+
+import (
+	"time"
+
+	"github.com/Kotodian/gokit/workpool"
+)
+
+// DispatchStopTimeout 连接断开时等待排队的报文处理完的时间
+const DispatchStopTimeout = 10 * time.Second
+
+// Dispatcher 分发桩上传的报文
+// maxInflight为0时每条报文一个goroutine, 否则按接收顺序串行处理,
+// 排队的报文达到maxInflight时Dispatch阻塞, ReadPump随之暂停读取
+type Dispatcher struct {
+	wp *workpool.WorkPool
+}
+
+func NewDispatcher(maxInflight int) *Dispatcher {
+	d := &Dispatcher{}
+	if maxInflight > 0 {
+		d.wp = workpool.New(1, maxInflight).Start()
+	}
+	return d
+}
+
+// Dispatch 提交一条报文的处理函数
+func (d *Dispatcher) Dispatch(f func()) {
+	if d.wp == nil {
+		go f()
+		return
+	}
+	d.wp.PushTaskFunc(func(w *workpool.WorkPool, args ...interface{}) workpool.Flag {
+		f()
+		return workpool.FLAG_OK
+	})
+}
+
+// Stop 等待已经提交的报文处理完后退出, 超过timeout时返回false,
+// 剩余的报文在后台继续处理, 处理完后再释放workpool
+func (d *Dispatcher) Stop(timeout time.Duration) bool {
+	if d.wp == nil {
+		return true
+	}
+	done := make(chan struct{})
+	// 队列已满时Dispatch也会阻塞
+	go d.Dispatch(func() {
+		close(done)
+	})
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		d.wp.Stop()
+		return true
+	case <-timer.C:
+		go func() {
+			<-done
+			d.wp.Stop()
+		}()
+		return false
+	}
+}
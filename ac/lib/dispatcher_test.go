@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDispatcherOrder(t *testing.T) {
+	d := NewDispatcher(2)
+	var got []int
+	for i := 0; i < 100; i++ {
+		i := i
+		d.Dispatch(func() {
+			got = append(got, i)
+		})
+	}
+	assert.True(t, d.Stop(time.Second))
+	assert.Len(t, got, 100)
+	for i, v := range got {
+		assert.Equal(t, i, v)
+	}
+}
+
+func TestDispatcherStopTimeout(t *testing.T) {
+	d := NewDispatcher(1)
+	block := make(chan struct{})
+	finished := make(chan struct{})
+	d.Dispatch(func() {
+		<-block
+	})
+	d.Dispatch(func() {
+		close(finished)
+	})
+	// 处理函数阻塞时Stop不能一直等待
+	start := time.Now()
+	assert.False(t, d.Stop(20*time.Millisecond))
+	assert.Less(t, time.Since(start), time.Second)
+	// 剩余的报文在后台继续处理
+	close(block)
+	<-finished
+}
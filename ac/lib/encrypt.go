@@ -1,11 +1,14 @@
 package lib
 
 import (
-	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
+	"crypto/rand"
 	"errors"
+	"fmt"
+
+	"github.com/Kotodian/gokit/ac/lib/padding"
 )
 
 type Encrypt interface {
@@ -14,8 +17,15 @@ type Encrypt interface {
 	Decode(data []byte, key []byte) ([]byte, error)
 }
 
-type aesEncrypt struct {
+// blockEncrypt 使用分组密码(AES, SM4)的加密方式
+type blockEncrypt struct {
 	mode AESMode
+	// iv 固定的IV, 为空时每条报文使用随机的IV并放在密文的开头
+	iv []byte
+	// cbcType CBC模式的类型, 其他模式依次加1
+	cbcType byte
+	// newBlock 检查密钥长度并创建分组密码
+	newBlock func(key []byte) (cipher.Block, error)
 }
 
 type AESMode string
@@ -24,132 +34,292 @@ const (
 	CBC AESMode = "cbc"
 	ECB AESMode = "ecb"
 	CFB AESMode = "cfb"
+	CTR AESMode = "ctr"
+	GCM AESMode = "gcm"
 )
 
 var (
 	ErrAESNotFound = errors.New("aes mode not found")
+	// ErrKeySize AES的密钥长度必须为16, 24或者32字节, SM4为16字节
+	ErrKeySize = errors.New("invalid key size")
+	// ErrIVSize 固定IV的长度与模式不符, GCM为12字节, 其他为16字节
+	ErrIVSize = errors.New("invalid iv size")
+	// ErrCiphertext 密文长度错误, 填充错误或者认证失败, 填充错误时同时为padding.ErrInvalidPadding
+	ErrCiphertext = errors.New("invalid ciphertext")
+	// ErrBlockSize 密文长度不是块长度的整数倍
+	ErrBlockSize = errors.New("input not full blocks")
 )
 
-func NewAESEncrypt(mode AESMode) Encrypt {
-	return &aesEncrypt{mode: mode}
+// defaultCBCIV AES CBC默认的固定IV, 已经部署的桩使用该格式
+var defaultCBCIV = []byte("1234567890ABCDEF")
+
+type aesOptions struct {
+	iv       []byte
+	randomIV bool
+}
+
+type AESOption func(*aesOptions)
+
+// WithIV 使用固定的IV(GCM为nonce), 密文中不包含IV, 用于只支持固定IV的桩
+func WithIV(iv []byte) AESOption {
+	return func(o *aesOptions) {
+		o.iv = append([]byte(nil), iv...)
+	}
+}
+
+// WithRandomIV 每条报文使用随机的IV, 加密结果为IV+密文, 用于AES CBC, 其他模式默认就是随机IV
+func WithRandomIV() AESOption {
+	return func(o *aesOptions) {
+		o.iv = nil
+		o.randomIV = true
+	}
+}
+
+// NewAESEncrypt CBC默认使用固定的IV 1234567890ABCDEF, 与以前的格式相同, 新接入的桩建议使用WithRandomIV;
+// CFB, CTR, GCM默认每条报文使用随机的IV, 加密结果为IV+密文, ECB不使用IV
+func NewAESEncrypt(mode AESMode, opts ...AESOption) Encrypt {
+	var o aesOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if mode == CBC && o.iv == nil && !o.randomIV {
+		o.iv = defaultCBCIV
+	}
+	return &blockEncrypt{mode: mode, iv: o.iv, cbcType: EncryptTypeAESCBC, newBlock: newAESCipher}
+}
+
+func newAESCipher(key []byte) (cipher.Block, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("%w %d", ErrKeySize, len(key))
+	}
+	return aes.NewCipher(key)
 }
 
-func (a *aesEncrypt) Type() byte {
-	return 0x00
+func (a *blockEncrypt) Type() byte {
+	switch a.mode {
+	case ECB:
+		return a.cbcType + 1
+	case CFB:
+		return a.cbcType + 2
+	case CTR:
+		return a.cbcType + 3
+	case GCM:
+		return a.cbcType + 4
+	default:
+		return a.cbcType
+	}
 }
 
-func (a *aesEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
+func (a *blockEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
+	block, err := a.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
 	switch a.mode {
 	case CBC:
-		return a.cbcEncrypt(data, key)
-	case CFB:
+		return a.cbcEncrypt(block, data)
+	case CFB, CTR:
+		return a.streamEncrypt(block, data)
 	case ECB:
+		return a.ecbEncrypt(block, data)
+	case GCM:
+		return a.gcmEncrypt(block, data)
 	default:
 		return nil, ErrAESNotFound
 	}
-	return nil, nil
 }
 
-func (a *aesEncrypt) Decode(data []byte, key []byte) ([]byte, error) {
+func (a *blockEncrypt) Decode(data []byte, key []byte) ([]byte, error) {
+	block, err := a.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
 	switch a.mode {
 	case CBC:
-		return a.cbcDecrypt(data, key)
+		return a.cbcDecrypt(block, data)
+	case CFB, CTR:
+		return a.streamDecrypt(block, data)
+	case ECB:
+		return a.ecbDecrypt(block, data)
+	case GCM:
+		return a.gcmDecrypt(block, data)
+	default:
+		return nil, ErrAESNotFound
 	}
-	return nil, nil
 }
 
-func (a *aesEncrypt) cbcEncrypt(data []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+// nonce 返回加密使用的IV以及密文的前缀, 使用固定IV时前缀为空
+func (a *blockEncrypt) nonce(size int) (iv []byte, prefix []byte, err error) {
+	if a.iv != nil {
+		if len(a.iv) != size {
+			return nil, nil, ErrIVSize
+		}
+		return a.iv, nil, nil
+	}
+	iv = make([]byte, size)
+	if _, err = rand.Read(iv); err != nil {
+		return nil, nil, err
+	}
+	return iv, iv, nil
+}
+
+// splitNonce 从密文中取出IV, 使用固定IV时密文不变
+func (a *blockEncrypt) splitNonce(data []byte, size int) (iv []byte, rest []byte, err error) {
+	if a.iv != nil {
+		if len(a.iv) != size {
+			return nil, nil, ErrIVSize
+		}
+		return a.iv, data, nil
+	}
+	if len(data) < size {
+		return nil, nil, fmt.Errorf("%w: missing iv", ErrCiphertext)
+	}
+	return data[:size], data[size:], nil
+}
+
+func (a *blockEncrypt) streamCipher(block cipher.Block, iv []byte, encrypt bool) cipher.Stream {
+	if a.mode == CTR {
+		return cipher.NewCTR(block, iv)
+	}
+	if encrypt {
+		return cipher.NewCFBEncrypter(block, iv)
+	}
+	return cipher.NewCFBDecrypter(block, iv)
+}
+
+func (a *blockEncrypt) cbcEncrypt(block cipher.Block, data []byte) ([]byte, error) {
+	iv, prefix, err := a.nonce(block.BlockSize())
 	if err != nil {
 		return nil, err
 	}
-	blockSize := block.BlockSize()
-	data = a.pkcs5Padding(data, blockSize)
-	blockMode := cipher.NewCBCEncrypter(block, []byte("1234567890ABCDEF"))
-	encrypted := make([]byte, len(data))
-	blockMode.CryptBlocks(encrypted, data)
+	data = padding.PKCS7Pad(data, block.BlockSize())
+	encrypted := make([]byte, len(prefix)+len(data))
+	copy(encrypted, prefix)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted[len(prefix):], data)
 	return encrypted, nil
 }
 
-func (a *aesEncrypt) cbcDecrypt(encrypted []byte, key []byte) ([]byte, error) {
-	block, err := aes.NewCipher(key)
+func (a *blockEncrypt) cbcDecrypt(block cipher.Block, encrypted []byte) ([]byte, error) {
+	iv, encrypted, err := a.splitNonce(encrypted, block.BlockSize())
 	if err != nil {
 		return nil, err
 	}
-	blockMode := cipher.NewCBCDecrypter(block, []byte("1234567890ABCDEF"))
+	if len(encrypted) == 0 || len(encrypted)%block.BlockSize() != 0 {
+		return nil, fmt.Errorf("%w: not full blocks", ErrCiphertext)
+	}
 	decrypted := make([]byte, len(encrypted))
-	blockMode.CryptBlocks(decrypted, encrypted)
-	decrypted = a.pkcs5UnPadding(decrypted)
-	return decrypted, nil
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, encrypted)
+	return a.unpad(decrypted, block.BlockSize())
 }
 
-func (a *aesEncrypt) ecbEncrypt(data []byte, key []byte) ([]byte, error) {
-	cipher, err := aes.NewCipher(a.generateKey(key))
+func (a *blockEncrypt) streamEncrypt(block cipher.Block, data []byte) ([]byte, error) {
+	iv, prefix, err := a.nonce(block.BlockSize())
 	if err != nil {
 		return nil, err
 	}
-	length := (len(data) + aes.BlockSize) / aes.BlockSize
-	plain := make([]byte, length*aes.BlockSize)
-	copy(plain, data)
-	pad := byte(len(plain) - len(data))
-	for i := len(data); i < len(plain); i++ {
-		plain[i] = pad
-	}
-	encrypted := make([]byte, len(plain))
-	for bs, be := 0, cipher.BlockSize(); bs <= len(data); bs, be = bs+cipher.BlockSize(), be+cipher.BlockSize() {
-		cipher.Encrypt(encrypted[bs:be], plain[bs:be])
-	}
+	encrypted := make([]byte, len(prefix)+len(data))
+	copy(encrypted, prefix)
+	a.streamCipher(block, iv, true).XORKeyStream(encrypted[len(prefix):], data)
 	return encrypted, nil
 }
 
-func (a *aesEncrypt) generateKey(key []byte) (genKey []byte) {
-	genKey = make([]byte, 16)
-	copy(genKey, key)
-	for i := 16; i < len(key); {
-		for j := 0; j < 16 && i < len(key); j, i = j+1, i+1 {
-			genKey[j] ^= key[i]
-		}
+func (a *blockEncrypt) streamDecrypt(block cipher.Block, encrypted []byte) ([]byte, error) {
+	iv, encrypted, err := a.splitNonce(encrypted, block.BlockSize())
+	if err != nil {
+		return nil, err
 	}
-	return genKey
+	decrypted := make([]byte, len(encrypted))
+	a.streamCipher(block, iv, false).XORKeyStream(decrypted, encrypted)
+	return decrypted, nil
 }
-func (a *aesEncrypt) pkcs5UnPadding(origData []byte) []byte {
-	length := len(origData)
-	unpadding := int(origData[length-1])
-	return origData[:(length - unpadding)]
+
+func (a *blockEncrypt) ecbEncrypt(block cipher.Block, data []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	data = padding.PKCS7Pad(data, bs)
+	encrypted := make([]byte, len(data))
+	for i := 0; i < len(data); i += bs {
+		block.Encrypt(encrypted[i:i+bs], data[i:i+bs])
+	}
+	return encrypted, nil
 }
 
-func (a *aesEncrypt) pkcs5Padding(ciphertext []byte, blockSize int) []byte {
-	padding := blockSize - len(ciphertext)%blockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(ciphertext, padtext...)
+func (a *blockEncrypt) ecbDecrypt(block cipher.Block, encrypted []byte) ([]byte, error) {
+	bs := block.BlockSize()
+	if len(encrypted) == 0 || len(encrypted)%bs != 0 {
+		return nil, fmt.Errorf("%w: not full blocks", ErrCiphertext)
+	}
+	decrypted := make([]byte, len(encrypted))
+	for i := 0; i < len(encrypted); i += bs {
+		block.Decrypt(decrypted[i:i+bs], encrypted[i:i+bs])
+	}
+	return a.unpad(decrypted, bs)
 }
 
-type rsaEncrypt struct {
+func (a *blockEncrypt) gcmEncrypt(block cipher.Block, data []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv, prefix, err := a.nonce(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, len(prefix), len(prefix)+len(data)+gcm.Overhead())
+	copy(encrypted, prefix)
+	return gcm.Seal(encrypted, iv, data, nil), nil
 }
 
-func (r *rsaEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
-	panic("implement me")
+func (a *blockEncrypt) gcmDecrypt(block cipher.Block, encrypted []byte) ([]byte, error) {
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	iv, encrypted, err := a.splitNonce(encrypted, gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	decrypted, err := gcm.Open(nil, iv, encrypted, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrCiphertext, err.Error())
+	}
+	return decrypted, nil
 }
 
-func (r *rsaEncrypt) Decode(data []byte, key []byte) ([]byte, error) {
-	panic("implement me")
+func (a *blockEncrypt) unpad(data []byte, blockSize int) ([]byte, error) {
+	data, err := padding.PKCS7Unpad(data, blockSize)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCiphertext, err)
+	}
+	return data, nil
 }
 
 // @brief:填充明文
+// Deprecated: 使用padding.PKCS7Pad
 func PKCS5Padding(plaintext []byte, blockSize int) []byte {
-	padding := blockSize - len(plaintext)%blockSize
-	padtext := bytes.Repeat([]byte{byte(padding)}, padding)
-	return append(plaintext, padtext...)
+	return padding.PKCS7Pad(plaintext, blockSize)
 }
 
-// @brief:去除填充数据
+// @brief:去除填充数据, 不检查块长度, 填充错误时返回nil
+// Deprecated: 使用padding.PKCS7Unpad
 func PKCS5UnPadding(origData []byte) []byte {
 	length := len(origData)
+	if length == 0 {
+		return nil
+	}
 	unpadding := int(origData[length-1])
+	if unpadding == 0 || unpadding > length {
+		return nil
+	}
+	if _, err := padding.PKCS7Unpad(origData[length-unpadding:], unpadding); err != nil {
+		return nil
+	}
 	return origData[:(length - unpadding)]
 }
 
-// @brief:AES加密
+// @brief:AES加密, IV固定为1234567890ABCDEF
+// Deprecated: 使用NewAESEncrypt(CBC), 加密结果相同
 func AesEncrypt(origData, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -158,14 +328,15 @@ func AesEncrypt(origData, key []byte) ([]byte, error) {
 
 	//AES分组长度为128位，所以blockSize=16，单位字节
 	blockSize := block.BlockSize()
-	origData = PKCS5Padding(origData, blockSize)
+	origData = padding.PKCS7Pad(origData, blockSize)
 	blockMode := cipher.NewCBCEncrypter(block, []byte("1234567890ABCDEF")) //初始向量的长度必须等于块block的长度16字节
 	crypted := make([]byte, len(origData))
 	blockMode.CryptBlocks(crypted, origData)
 	return crypted, nil
 }
 
-// @brief:AES解密
+// @brief:AES解密, IV为密钥的前16字节, 与AesEncrypt不同, 不能解密AesEncrypt的结果
+// Deprecated: 使用NewAESEncrypt(CBC)解密AesEncrypt的结果, 使用NewAESEncrypt(CBC, WithIV(key[:16]))兼容原有的AesDecrypt
 func AesDecrypt(crypted, key []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
@@ -174,11 +345,13 @@ func AesDecrypt(crypted, key []byte) ([]byte, error) {
 
 	//AES分组长度为128位，所以blockSize=16，单位字节
 	blockSize := block.BlockSize()
+	if len(crypted)%blockSize != 0 {
+		return nil, ErrBlockSize
+	}
 	blockMode := cipher.NewCBCDecrypter(block, key[:blockSize]) //初始向量的长度必须等于块block的长度16字节
 	origData := make([]byte, len(crypted))
 	blockMode.CryptBlocks(origData, crypted)
-	origData = PKCS5UnPadding(origData)
-	return origData, nil
+	return padding.PKCS7Unpad(origData, blockSize)
 }
 
 // 3des加密
@@ -190,7 +363,7 @@ func TripAES() Encrypt {
 }
 
 func (a *triple) Type() byte {
-	return 0x00
+	return EncryptTypeTripleDES
 }
 
 func (t *triple) Encode(data []byte, key []byte) ([]byte, error) {
@@ -198,7 +371,7 @@ func (t *triple) Encode(data []byte, key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	data = PKCS5Padding(data, block.BlockSize())
+	data = padding.PKCS5Pad(data)
 	blockMode := cipher.NewCBCEncrypter(block, key[:8])
 	crypt := make([]byte, len(data))
 	blockMode.CryptBlocks(crypt, data)
@@ -210,11 +383,13 @@ func (t *triple) Decode(data []byte, key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(data)%block.BlockSize() != 0 {
+		return nil, ErrBlockSize
+	}
 	ctx := make([]byte, len(data))
-	blockMode := cipher.NewCBCEncrypter(block, key[:8])
+	blockMode := cipher.NewCBCDecrypter(block, key[:8])
 	blockMode.CryptBlocks(ctx, data)
-	ctx = PKCS5UnPadding(ctx)
-	return ctx, nil
+	return padding.PKCS5Unpad(ctx)
 }
 
 type cbcEncrypt struct {
@@ -225,7 +400,7 @@ func NewCBCEncrypt() Encrypt {
 }
 
 func (a *cbcEncrypt) Type() byte {
-	return 0x02
+	return EncryptTypeDESCBC
 }
 
 func (a *cbcEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
@@ -233,8 +408,7 @@ func (a *cbcEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
-	blockSize := block.BlockSize()
-	data = PKCS5Padding(data, blockSize)
+	data = padding.PKCS5Pad(data)
 	blockMode := cipher.NewCBCEncrypter(block, key)
 	encrypted := make([]byte, len(data))
 	blockMode.CryptBlocks(encrypted, data)
@@ -246,11 +420,13 @@ func (a *cbcEncrypt) Decode(encrypted []byte, key []byte) ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	if len(encrypted)%block.BlockSize() != 0 {
+		return nil, ErrBlockSize
+	}
 	blockMode := cipher.NewCBCDecrypter(block, key)
 	decrypted := make([]byte, len(encrypted))
 	blockMode.CryptBlocks(decrypted, encrypted)
-	decrypted = PKCS5UnPadding(decrypted)
-	return decrypted, nil
+	return padding.PKCS5Unpad(decrypted)
 }
 
 type ecbEncypt struct {
@@ -261,7 +437,7 @@ func NewECBEncrypt() Encrypt {
 }
 
 func (a *ecbEncypt) Type() byte {
-	return 0x03
+	return EncryptTypeDESECB
 }
 
 func (a *ecbEncypt) Encode(data []byte, key []byte) ([]byte, error) {
@@ -271,10 +447,7 @@ func (a *ecbEncypt) Encode(data []byte, key []byte) ([]byte, error) {
 	}
 
 	bs := block.BlockSize()
-	data = PKCS5Padding(data, bs)
-	if len(data)%bs != 0 {
-		return nil, errors.New("Need a multiple of the blocksize")
-	}
+	data = padding.PKCS5Pad(data)
 	ciphertext := make([]byte, len(data))
 	dst := ciphertext
 	for len(data) > 0 {
@@ -292,7 +465,7 @@ func (a *ecbEncypt) Decode(data []byte, key []byte) ([]byte, error) {
 	}
 	bs := block.BlockSize()
 	if len(data)%bs != 0 {
-		return nil, errors.New("input not full blocks")
+		return nil, ErrBlockSize
 	}
 	plaintext := make([]byte, len(data))
 	dst := plaintext
@@ -301,6 +474,5 @@ func (a *ecbEncypt) Decode(data []byte, key []byte) ([]byte, error) {
 		data = data[bs:]
 		dst = dst[bs:]
 	}
-	plaintext = PKCS5UnPadding(plaintext)
-	return plaintext, nil
+	return padding.PKCS5Unpad(plaintext)
 }
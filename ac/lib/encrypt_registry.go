@@ -0,0 +1,175 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// 内置加密方式的类型, 写在帧头中用于区分每一帧的加密方式
+const (
+	// EncryptTypeNone 不加密, 桩交换密钥之前使用
+	EncryptTypeNone byte = 0x00
+	// EncryptTypeTripleDES 3DES-CBC
+	EncryptTypeTripleDES byte = 0x01
+	// EncryptTypeDESCBC DES-CBC
+	EncryptTypeDESCBC byte = 0x02
+	// EncryptTypeDESECB DES-ECB
+	EncryptTypeDESECB byte = 0x03
+	// EncryptTypeAESCBC AES-CBC
+	EncryptTypeAESCBC byte = 0x10
+	// EncryptTypeAESECB AES-ECB
+	EncryptTypeAESECB byte = 0x11
+	// EncryptTypeAESCFB AES-CFB
+	EncryptTypeAESCFB byte = 0x12
+	// EncryptTypeAESCTR AES-CTR
+	EncryptTypeAESCTR byte = 0x13
+	// EncryptTypeAESGCM AES-GCM
+	EncryptTypeAESGCM byte = 0x14
+	// EncryptTypeSM4CBC SM4-CBC
+	EncryptTypeSM4CBC byte = 0x20
+	// EncryptTypeSM4ECB SM4-ECB
+	EncryptTypeSM4ECB byte = 0x21
+	// EncryptTypeSM4CFB SM4-CFB
+	EncryptTypeSM4CFB byte = 0x22
+	// EncryptTypeSM4CTR SM4-CTR
+	EncryptTypeSM4CTR byte = 0x23
+	// EncryptTypeSM4GCM SM4-GCM
+	EncryptTypeSM4GCM byte = 0x24
+	// EncryptTypeSM2 SM2公钥加密
+	EncryptTypeSM2 byte = 0x30
+	// EncryptTypeRSAOAEP RSA-OAEP
+	EncryptTypeRSAOAEP byte = 0x40
+	// EncryptTypeRSAPKCS1v15 RSA-PKCS1v15
+	EncryptTypeRSAPKCS1v15 byte = 0x41
+)
+
+var (
+	// ErrEncryptNotFound 帧中的加密方式没有注册
+	ErrEncryptNotFound = errors.New("encrypt type not found")
+	// ErrEncryptRegistered 加密方式的类型重复
+	ErrEncryptRegistered = errors.New("encrypt type already registered")
+)
+
+// DefaultEncrypts 包含所有内置的对称加密方式, AES CBC与已经部署的桩兼容, 使用固定的IV,
+// AES的其他模式以及SM4每条报文使用随机的IV, RSA和SM2需要私钥, 使用时自行注册
+var DefaultEncrypts = MustEncryptRegistry(
+	NewPlainEncrypt(),
+	TripAES(),
+	NewCBCEncrypt(),
+	NewECBEncrypt(),
+	NewAESEncrypt(CBC),
+	NewAESEncrypt(ECB),
+	NewAESEncrypt(CFB),
+	NewAESEncrypt(CTR),
+	NewAESEncrypt(GCM),
+	NewSM4Encrypt(CBC),
+	NewSM4Encrypt(ECB),
+	NewSM4Encrypt(CFB),
+	NewSM4Encrypt(CTR),
+	NewSM4Encrypt(GCM),
+)
+
+// EncryptRegistry 按Encrypt.Type查找加密方式, 用于按帧选择解密方式
+type EncryptRegistry struct {
+	mu       sync.RWMutex
+	encrypts map[byte]Encrypt
+}
+
+// NewEncryptRegistry 类型重复时返回ErrEncryptRegistered
+func NewEncryptRegistry(encrypts ...Encrypt) (*EncryptRegistry, error) {
+	r := &EncryptRegistry{encrypts: make(map[byte]Encrypt)}
+	if err := r.Register(encrypts...); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// MustEncryptRegistry 类型重复时panic
+func MustEncryptRegistry(encrypts ...Encrypt) *EncryptRegistry {
+	r, err := NewEncryptRegistry(encrypts...)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// Register 注册加密方式, 有一个类型重复时都不注册
+func (r *EncryptRegistry) Register(encrypts ...Encrypt) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[byte]struct{}, len(encrypts))
+	for _, encrypt := range encrypts {
+		t := encrypt.Type()
+		_, registered := r.encrypts[t]
+		if _, ok := seen[t]; ok || registered {
+			return fmt.Errorf("%w: 0x%02X", ErrEncryptRegistered, t)
+		}
+		seen[t] = struct{}{}
+	}
+	for _, encrypt := range encrypts {
+		r.encrypts[encrypt.Type()] = encrypt
+	}
+	return nil
+}
+
+// Replace 注册或者替换加密方式, 例如使用固定IV的AES替换默认的AES
+func (r *EncryptRegistry) Replace(encrypt Encrypt) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.encrypts[encrypt.Type()] = encrypt
+}
+
+// Get 返回类型为t的加密方式
+func (r *EncryptRegistry) Get(t byte) (Encrypt, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	encrypt, ok := r.encrypts[t]
+	if !ok {
+		return nil, fmt.Errorf("%w: 0x%02X", ErrEncryptNotFound, t)
+	}
+	return encrypt, nil
+}
+
+// Types 已经注册的类型, 从小到大排列
+func (r *EncryptRegistry) Types() []byte {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	types := make([]byte, 0, len(r.encrypts))
+	for t := range r.encrypts {
+		types = append(types, t)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// Decode 使用类型为t的加密方式解密
+func (r *EncryptRegistry) Decode(t byte, data []byte, key []byte) ([]byte, error) {
+	encrypt, err := r.Get(t)
+	if err != nil {
+		return nil, err
+	}
+	return encrypt.Decode(data, key)
+}
+
+// plainEncrypt 不加密
+type plainEncrypt struct {
+}
+
+// NewPlainEncrypt 原样返回数据, 类型为EncryptTypeNone
+func NewPlainEncrypt() Encrypt {
+	return &plainEncrypt{}
+}
+
+func (p *plainEncrypt) Type() byte {
+	return EncryptTypeNone
+}
+
+func (p *plainEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (p *plainEncrypt) Decode(data []byte, key []byte) ([]byte, error) {
+	return data, nil
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncryptRegistry(t *testing.T) {
+	// 内置的加密方式类型不重复
+	assert.Equal(t, []byte{
+		EncryptTypeNone, EncryptTypeTripleDES, EncryptTypeDESCBC, EncryptTypeDESECB,
+		EncryptTypeAESCBC, EncryptTypeAESECB, EncryptTypeAESCFB, EncryptTypeAESCTR, EncryptTypeAESGCM,
+		EncryptTypeSM4CBC, EncryptTypeSM4ECB, EncryptTypeSM4CFB, EncryptTypeSM4CTR, EncryptTypeSM4GCM,
+	}, DefaultEncrypts.Types())
+
+	registry, err := NewEncryptRegistry(NewPlainEncrypt(), NewAESEncrypt(GCM))
+	assert.Nil(t, err)
+	_, err = registry.Get(EncryptTypeAESCBC)
+	assert.True(t, errors.Is(err, ErrEncryptNotFound))
+
+	// 有一个重复时都不注册
+	err = registry.Register(NewAESEncrypt(CBC), NewAESEncrypt(GCM))
+	assert.True(t, errors.Is(err, ErrEncryptRegistered))
+	_, err = registry.Get(EncryptTypeAESCBC)
+	assert.True(t, errors.Is(err, ErrEncryptNotFound))
+	_, err = NewEncryptRegistry(NewAESEncrypt(CBC), NewAESEncrypt(CBC, WithIV([]byte("1234567890ABCDEF"))))
+	assert.True(t, errors.Is(err, ErrEncryptRegistered))
+
+	key := []byte("1234567812345678")
+	fixed := NewAESEncrypt(GCM, WithIV([]byte("1234567890AB")))
+	registry.Replace(fixed)
+	data, _ := fixed.Encode([]byte("123456"), key)
+	text, err := registry.Decode(EncryptTypeAESGCM, data, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+
+	text, err = registry.Decode(EncryptTypeNone, []byte("123456"), key)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+}
@@ -0,0 +1,205 @@
+package lib
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	// OAEP默认使用SHA256
+	_ "crypto/sha256"
+)
+
+// RSAPadding RSA加密的填充方式
+type RSAPadding string
+
+const (
+	OAEP     RSAPadding = "oaep"
+	PKCS1v15 RSAPadding = "pkcs1v15"
+)
+
+type rsaEncrypt struct {
+	padding    RSAPadding
+	hash       crypto.Hash
+	privateKey *rsa.PrivateKey
+}
+
+type rsaOptions struct {
+	hash       crypto.Hash
+	privateKey *rsa.PrivateKey
+}
+
+type RSAOption func(*rsaOptions)
+
+// WithOAEPHash OAEP使用的hash, 默认为SHA256, 需要导入对应的包
+func WithOAEPHash(hash crypto.Hash) RSAOption {
+	return func(o *rsaOptions) {
+		o.hash = hash
+	}
+}
+
+// WithRSAPrivateKey Decode的key为空时使用的私钥
+func WithRSAPrivateKey(privateKey *rsa.PrivateKey) RSAOption {
+	return func(o *rsaOptions) {
+		o.privateKey = privateKey
+	}
+}
+
+// NewRSAEncrypt Encode的key为公钥(PKIX或PKCS#1), Decode的key为私钥(PKCS#1或PKCS#8), 支持PEM和DER,
+// 超过一个分组的数据分段加密, 密文为各段密文的拼接
+func NewRSAEncrypt(padding RSAPadding, opts ...RSAOption) Encrypt {
+	o := rsaOptions{hash: crypto.SHA256}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &rsaEncrypt{padding: padding, hash: o.hash, privateKey: o.privateKey}
+}
+
+func (r *rsaEncrypt) Type() byte {
+	if r.padding == PKCS1v15 {
+		return EncryptTypeRSAPKCS1v15
+	}
+	return EncryptTypeRSAOAEP
+}
+
+func (r *rsaEncrypt) check() error {
+	switch {
+	case r.padding == PKCS1v15:
+		return nil
+	case r.padding != OAEP:
+		return fmt.Errorf("rsa padding %s not found", r.padding)
+	case !r.hash.Available():
+		return fmt.Errorf("rsa oaep hash %d not available", r.hash)
+	}
+	return nil
+}
+
+// maxChunk 每段明文的最大长度
+func (r *rsaEncrypt) maxChunk(size int) int {
+	if r.padding == PKCS1v15 {
+		return size - 11
+	}
+	return size - 2*r.hash.Size() - 2
+}
+
+// Encode key为空时使用私钥对应的公钥
+func (r *rsaEncrypt) Encode(data []byte, key []byte) ([]byte, error) {
+	var publicKey *rsa.PublicKey
+	if len(key) == 0 && r.privateKey != nil {
+		publicKey = &r.privateKey.PublicKey
+	} else {
+		var err error
+		if publicKey, err = ParseRSAPublicKey(key); err != nil {
+			return nil, err
+		}
+	}
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	chunk := r.maxChunk(publicKey.Size())
+	if chunk <= 0 {
+		return nil, fmt.Errorf("%w %d", ErrKeySize, publicKey.Size())
+	}
+	encrypted := make([]byte, 0, (len(data)/chunk+1)*publicKey.Size())
+	for {
+		n := len(data)
+		if n > chunk {
+			n = chunk
+		}
+		var block []byte
+		var err error
+		if r.padding == PKCS1v15 {
+			block, err = rsa.EncryptPKCS1v15(rand.Reader, publicKey, data[:n])
+		} else {
+			block, err = rsa.EncryptOAEP(r.hash.New(), rand.Reader, publicKey, data[:n], nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+		encrypted = append(encrypted, block...)
+		if data = data[n:]; len(data) == 0 {
+			return encrypted, nil
+		}
+	}
+}
+
+// Decode PKCS1v15的填充错误返回ErrCiphertext, 不能用于解密桩上传的会话密钥(Bleichenbacher攻击), 应当使用KeyExchange
+func (r *rsaEncrypt) Decode(data []byte, key []byte) ([]byte, error) {
+	privateKey, err := r.private(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.check(); err != nil {
+		return nil, err
+	}
+	size := privateKey.Size()
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("%w: length %d", ErrCiphertext, len(data))
+	}
+	var decrypted []byte
+	for ; len(data) > 0; data = data[size:] {
+		var block []byte
+		var err error
+		if r.padding == PKCS1v15 {
+			block, err = rsa.DecryptPKCS1v15(nil, privateKey, data[:size])
+		} else {
+			block, err = rsa.DecryptOAEP(r.hash.New(), nil, privateKey, data[:size], nil)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCiphertext, err)
+		}
+		decrypted = append(decrypted, block...)
+	}
+	return decrypted, nil
+}
+
+// private key不为空时解析key, 否则使用配置的私钥
+func (r *rsaEncrypt) private(key []byte) (*rsa.PrivateKey, error) {
+	if len(key) > 0 {
+		return ParseRSAPrivateKey(key)
+	}
+	if r.privateKey == nil {
+		return nil, ErrPrivateKey
+	}
+	return r.privateKey, nil
+}
+
+// ParseRSAPublicKey 解析PEM或者DER格式的公钥, 支持PKIX以及PKCS#1
+func ParseRSAPublicKey(key []byte) (*rsa.PublicKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		key = block.Bytes
+	}
+	if publicKey, err := x509.ParsePKCS1PublicKey(key); err == nil {
+		return publicKey, nil
+	}
+	publicKey, err := x509.ParsePKIXPublicKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPublicKey, err)
+	}
+	rsaPublicKey, ok := publicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not rsa public key", ErrPublicKey)
+	}
+	return rsaPublicKey, nil
+}
+
+// ParseRSAPrivateKey 解析PEM或者DER格式的私钥, 支持PKCS#1以及PKCS#8
+func ParseRSAPrivateKey(key []byte) (*rsa.PrivateKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		key = block.Bytes
+	}
+	if privateKey, err := x509.ParsePKCS1PrivateKey(key); err == nil {
+		return privateKey, nil
+	}
+	privateKey, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrPrivateKey, err)
+	}
+	rsaPrivateKey, ok := privateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: not rsa private key", ErrPrivateKey)
+	}
+	return rsaPrivateKey, nil
+}
@@ -0,0 +1,121 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRSAEncrypt(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	publicDER, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	privateDER, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	privatePKCS1 := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	// 超过一个分组的数据分段加密
+	text := strings.Repeat("0123456789ABCDEF", 20)
+	for _, padding := range []RSAPadding{OAEP, PKCS1v15} {
+		encrypt := NewRSAEncrypt(padding)
+		data, err := encrypt.Encode([]byte(text), publicPEM)
+		assert.Nil(t, err, padding)
+		assert.Equal(t, 0, len(data)%privateKey.Size())
+		for _, key := range [][]byte{privateDER, privatePKCS1} {
+			text2, err := encrypt.Decode(data, key)
+			assert.Nil(t, err, padding)
+			assert.Equal(t, text, string(text2), padding)
+		}
+
+		withKey := NewRSAEncrypt(padding, WithRSAPrivateKey(privateKey))
+		data, err = withKey.Encode([]byte("123456"), nil)
+		assert.Nil(t, err)
+		text2, err := withKey.Decode(data, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, "123456", string(text2))
+
+		_, err = encrypt.Decode(data, nil)
+		assert.True(t, errors.Is(err, ErrPrivateKey))
+		_, err = encrypt.Decode(data[1:], privateDER)
+		assert.True(t, errors.Is(err, ErrCiphertext))
+		data[0] ^= 0xff
+		_, err = encrypt.Decode(data, privateDER)
+		assert.True(t, errors.Is(err, ErrCiphertext))
+	}
+	assert.NotEqual(t, NewRSAEncrypt(OAEP).Type(), NewRSAEncrypt(PKCS1v15).Type())
+
+	_, err = NewRSAEncrypt(OAEP).Encode([]byte("123456"), []byte("key"))
+	assert.True(t, errors.Is(err, ErrPublicKey))
+}
+
+func TestKeyExchange(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	publicDER := x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)
+	decrypt := NewRSAEncrypt(OAEP, WithRSAPrivateKey(privateKey))
+	exchange := NewKeyExchange(decrypt, NewAESEncrypt(GCM), 16, 32)
+
+	// 桩使用平台的公钥加密会话密钥
+	sessionKey := []byte("1234567812345678")
+	encrypted, err := decrypt.Encode(sessionKey, publicDER)
+	assert.Nil(t, err)
+
+	client := NewTestClient()
+	ctx := WithClient(context.Background(), client)
+	key, err := exchange.ExchangeCtx(ctx, encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, sessionKey, key)
+	assert.Equal(t, sessionKey, client.EncryptKey())
+	assert.Equal(t, EncryptTypeAESGCM, client.Encrypt().Type())
+
+	// 先回复再切换密钥
+	client = NewTestClient()
+	encrypted, _ = decrypt.Encode(sessionKey, publicDER)
+	key, err = exchange.Open(encrypted)
+	assert.Nil(t, err)
+	assert.Empty(t, client.EncryptKey())
+	exchange.Apply(client, key)
+	assert.Equal(t, sessionKey, client.EncryptKey())
+
+	encrypted, _ = decrypt.Encode([]byte("12345678"), publicDER)
+	_, err = exchange.Exchange(client, encrypted)
+	assert.True(t, errors.Is(err, ErrSessionKey))
+	_, err = exchange.Exchange(client, []byte("123456"))
+	assert.True(t, errors.Is(err, ErrCiphertext))
+	assert.Equal(t, sessionKey, client.EncryptKey())
+}
+
+func TestKeyExchangePKCS1v15(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+	publicDER := x509.MarshalPKCS1PublicKey(&privateKey.PublicKey)
+	decrypt := NewRSAEncrypt(PKCS1v15, WithRSAPrivateKey(privateKey))
+
+	sessionKey := []byte("1234567812345678")
+	encrypted, err := decrypt.Encode(sessionKey, publicDER)
+	assert.Nil(t, err)
+	_, err = NewKeyExchange(decrypt, nil).Open(encrypted)
+	assert.True(t, errors.Is(err, ErrSessionKey))
+
+	exchange := NewKeyExchange(decrypt, nil, 16)
+	key, err := exchange.Open(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, sessionKey, key)
+
+	// 填充错误时返回随机的密钥, 与正确的密文没有区别
+	encrypted[len(encrypted)-1] ^= 0xff
+	key, err = exchange.Open(encrypted)
+	assert.Nil(t, err)
+	assert.Len(t, key, 16)
+	assert.NotEqual(t, sessionKey, key)
+
+	_, err = exchange.Open(encrypted[1:])
+	assert.True(t, errors.Is(err, ErrCiphertext))
+}
@@ -0,0 +1,177 @@
+package lib
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/tjfoc/gmsm/sm2"
+	"github.com/tjfoc/gmsm/sm4"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+// NewSM4Encrypt 国密SM4, 密钥为16字节, 模式与NewAESEncrypt相同, 没有旧的格式需要兼容, 包括CBC在内默认都使用随机的IV
+func NewSM4Encrypt(mode AESMode, opts ...AESOption) Encrypt {
+	var o aesOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &blockEncrypt{mode: mode, iv: o.iv, cbcType: EncryptTypeSM4CBC, newBlock: newSM4Cipher}
+}
+
+func newSM4Cipher(key []byte) (cipher.Block, error) {
+	if len(key) != sm4.BlockSize {
+		return nil, fmt.Errorf("%w %d", ErrKeySize, len(key))
+	}
+	return sm4.NewCipher(key)
+}
+
+// SM2Mode SM2密文的排列顺序
+type SM2Mode int
+
+const (
+	// SM2C1C3C2 GB/T 32918-2016规定的顺序
+	SM2C1C3C2 SM2Mode = iota
+	// SM2C1C2C3 旧标准的顺序
+	SM2C1C2C3
+)
+
+// sm2CiphertextOverhead 04 + C1(64字节) + C3(32字节)
+const sm2CiphertextOverhead = 1 + 64 + 32
+
+// ErrPrivateKey 没有配置私钥或者私钥格式错误
+var ErrPrivateKey = errors.New("invalid private key")
+
+// ErrPublicKey 公钥格式错误
+var ErrPublicKey = errors.New("invalid public key")
+
+type sm2Encrypt struct {
+	mode       SM2Mode
+	privateKey *sm2.PrivateKey
+}
+
+type sm2Options struct {
+	mode       SM2Mode
+	privateKey *sm2.PrivateKey
+}
+
+type SM2Option func(*sm2Options)
+
+// WithSM2Mode 密文的排列顺序, 默认为SM2C1C3C2
+func WithSM2Mode(mode SM2Mode) SM2Option {
+	return func(o *sm2Options) {
+		o.mode = mode
+	}
+}
+
+// WithSM2PrivateKey Decode的key为空时使用的私钥
+func WithSM2PrivateKey(privateKey *sm2.PrivateKey) SM2Option {
+	return func(o *sm2Options) {
+		o.privateKey = privateKey
+	}
+}
+
+// NewSM2Encrypt 国密SM2公钥加密, Encode的key为公钥, Decode的key为私钥,
+// 支持PEM格式以及未压缩的公钥(04||X||Y)和32字节的私钥
+func NewSM2Encrypt(opts ...SM2Option) Encrypt {
+	var o sm2Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &sm2Encrypt{mode: o.mode, privateKey: o.privateKey}
+}
+
+func (s *sm2Encrypt) Type() byte {
+	return EncryptTypeSM2
+}
+
+// Encode key为空时使用私钥对应的公钥
+func (s *sm2Encrypt) Encode(data []byte, key []byte) ([]byte, error) {
+	var publicKey *sm2.PublicKey
+	if len(key) == 0 && s.privateKey != nil {
+		publicKey = &s.privateKey.PublicKey
+	} else {
+		var err error
+		if publicKey, err = ParseSM2PublicKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return sm2.Encrypt(publicKey, data, rand.Reader, int(s.mode))
+}
+
+func (s *sm2Encrypt) Decode(data []byte, key []byte) ([]byte, error) {
+	privateKey := s.privateKey
+	if len(key) > 0 {
+		var err error
+		if privateKey, err = ParseSM2PrivateKey(key); err != nil {
+			return nil, err
+		}
+	}
+	if privateKey == nil {
+		return nil, ErrPrivateKey
+	}
+	// sm2.Decrypt不检查长度
+	if len(data) < sm2CiphertextOverhead || data[0] != 0x04 {
+		return nil, fmt.Errorf("%w: length %d", ErrCiphertext, len(data))
+	}
+	if !privateKey.Curve.IsOnCurve(new(big.Int).SetBytes(data[1:33]), new(big.Int).SetBytes(data[33:65])) {
+		return nil, fmt.Errorf("%w: C1 not on curve", ErrCiphertext)
+	}
+	decrypted, err := sm2.Decrypt(privateKey, data, int(s.mode))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCiphertext, err)
+	}
+	return decrypted, nil
+}
+
+// ParseSM2PublicKey 解析PEM格式或者未压缩(04||X||Y, 可以没有04)的公钥
+func ParseSM2PublicKey(key []byte) (*sm2.PublicKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		publicKey, err := gmx509.ParseSm2PublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPublicKey, err)
+		}
+		return publicKey, nil
+	}
+	if len(key) == 65 && key[0] == 0x04 {
+		key = key[1:]
+	}
+	if len(key) != 64 {
+		return nil, fmt.Errorf("%w: length %d", ErrPublicKey, len(key))
+	}
+	publicKey := &sm2.PublicKey{
+		Curve: sm2.P256Sm2(),
+		X:     new(big.Int).SetBytes(key[:32]),
+		Y:     new(big.Int).SetBytes(key[32:]),
+	}
+	if !publicKey.Curve.IsOnCurve(publicKey.X, publicKey.Y) {
+		return nil, fmt.Errorf("%w: not on curve", ErrPublicKey)
+	}
+	return publicKey, nil
+}
+
+// ParseSM2PrivateKey 解析PEM格式(PKCS#8, 不加密)或者32字节的私钥
+func ParseSM2PrivateKey(key []byte) (*sm2.PrivateKey, error) {
+	if block, _ := pem.Decode(key); block != nil {
+		privateKey, err := gmx509.ParsePKCS8UnecryptedPrivateKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrPrivateKey, err)
+		}
+		return privateKey, nil
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%w: length %d", ErrPrivateKey, len(key))
+	}
+	curve := sm2.P256Sm2()
+	d := new(big.Int).SetBytes(key)
+	if d.Sign() == 0 || d.Cmp(new(big.Int).Sub(curve.Params().N, big.NewInt(1))) >= 0 {
+		return nil, fmt.Errorf("%w: out of range", ErrPrivateKey)
+	}
+	privateKey := &sm2.PrivateKey{D: d}
+	privateKey.PublicKey.Curve = curve
+	privateKey.PublicKey.X, privateKey.PublicKey.Y = curve.ScalarBaseMult(key)
+	return privateKey, nil
+}
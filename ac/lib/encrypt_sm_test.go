@@ -0,0 +1,73 @@
+package lib
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/tjfoc/gmsm/sm2"
+	gmx509 "github.com/tjfoc/gmsm/x509"
+)
+
+func TestSM4Encrypt(t *testing.T) {
+	// GB/T 32907-2016 附录A的示例
+	key, _ := hex.DecodeString("0123456789abcdeffedcba9876543210")
+	data, err := NewSM4Encrypt(ECB).Encode(key, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "681edf34d206965e86b3e94f536e4246", hex.EncodeToString(data[:16]))
+
+	for _, mode := range []AESMode{CBC, ECB, CFB, CTR, GCM} {
+		encrypt := NewSM4Encrypt(mode)
+		assert.Equal(t, NewAESEncrypt(mode).Type()+0x10, encrypt.Type())
+		data, err := encrypt.Encode([]byte("123456"), key)
+		assert.Nil(t, err, mode)
+		text, err := encrypt.Decode(data, key)
+		assert.Nil(t, err, mode)
+		assert.Equal(t, "123456", string(text), mode)
+	}
+
+	_, err = NewSM4Encrypt(CBC).Encode([]byte("123456"), []byte("0123456789ABCDEF01234567"))
+	assert.True(t, errors.Is(err, ErrKeySize))
+}
+
+func TestSM2Encrypt(t *testing.T) {
+	privateKey, err := sm2.GenerateKey(nil)
+	assert.Nil(t, err)
+	publicPEM, _ := gmx509.WritePublicKeyToPem(&privateKey.PublicKey)
+	privatePEM, _ := gmx509.WritePrivateKeyToPem(privateKey, nil)
+	publicRaw, _ := hex.DecodeString(gmx509.WritePublicKeyToHex(&privateKey.PublicKey))
+	privateRaw := make([]byte, 32)
+	privateKey.D.FillBytes(privateRaw)
+
+	for _, mode := range []SM2Mode{SM2C1C3C2, SM2C1C2C3} {
+		encrypt := NewSM2Encrypt(WithSM2Mode(mode))
+		for _, publicKey := range [][]byte{publicPEM, publicRaw, publicRaw[1:]} {
+			data, err := encrypt.Encode([]byte("123456"), publicKey)
+			assert.Nil(t, err)
+			assert.Len(t, data, sm2CiphertextOverhead+6)
+			for _, key := range [][]byte{privatePEM, privateRaw} {
+				text, err := encrypt.Decode(data, key)
+				assert.Nil(t, err)
+				assert.Equal(t, "123456", string(text))
+			}
+		}
+	}
+
+	encrypt := NewSM2Encrypt(WithSM2PrivateKey(privateKey))
+	data, err := encrypt.Encode([]byte("123456"), nil)
+	assert.Nil(t, err)
+	text, err := encrypt.Decode(data, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+
+	data[len(data)-1] ^= 0xff
+	_, err = encrypt.Decode(data, nil)
+	assert.True(t, errors.Is(err, ErrCiphertext))
+	_, err = encrypt.Decode(data[:50], nil)
+	assert.True(t, errors.Is(err, ErrCiphertext))
+	_, err = NewSM2Encrypt().Decode(data, nil)
+	assert.True(t, errors.Is(err, ErrPrivateKey))
+	_, err = encrypt.Encode([]byte("123456"), make([]byte, 64))
+	assert.True(t, errors.Is(err, ErrPublicKey))
+}
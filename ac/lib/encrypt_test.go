@@ -1,21 +1,116 @@
 package lib
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
 	"fmt"
 	"testing"
 
-	"github.com/magiconair/properties/assert"
+	"github.com/stretchr/testify/assert"
+	"github.com/tjfoc/gmsm/sm2"
 )
 
 func TestCBCEncrypt(t *testing.T) {
 	cbcEncrypt := NewAESEncrypt(CBC)
 
-	data, err := cbcEncrypt.Encode([]byte("0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF66"), []byte("12345678"))
+	text := "0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF66"
+	data, err := cbcEncrypt.Encode([]byte(text), []byte("1234567812345678"))
 	if err != nil {
 		t.Error(err)
 		return
 	}
 	fmt.Printf("%02X", data)
+	text2, err := cbcEncrypt.Decode(data, []byte("1234567812345678"))
+	assert.Nil(t, err)
+	assert.Equal(t, text, string(text2))
+
+	// 8字节的密钥不是合法的AES密钥
+	_, err = cbcEncrypt.Encode([]byte(text), []byte("12345678"))
+	assert.True(t, errors.Is(err, ErrKeySize))
+}
+
+func TestAESEncrypt(t *testing.T) {
+	key := []byte("0123456789ABCDEF0123456789ABCDEF")
+	for _, mode := range []AESMode{CBC, CFB, CTR, ECB, GCM} {
+		encrypt := NewAESEncrypt(mode)
+		for _, text := range []string{"", "123456", "0123456789ABCDEF"} {
+			data, err := encrypt.Encode([]byte(text), key)
+			assert.Nil(t, err, mode)
+			text2, err := encrypt.Decode(data, key)
+			assert.Nil(t, err, mode)
+			assert.Equal(t, text, string(text2), mode)
+		}
+		// 除了ECB和默认固定IV的CBC每次加密使用不同的IV
+		data1, _ := encrypt.Encode([]byte("123456"), key)
+		data2, _ := encrypt.Encode([]byte("123456"), key)
+		assert.Equal(t, mode == ECB || mode == CBC, string(data1) == string(data2), mode)
+	}
+
+	random := NewAESEncrypt(CBC, WithRandomIV())
+	data1, _ := random.Encode([]byte("123456"), key)
+	data2, _ := random.Encode([]byte("123456"), key)
+	assert.NotEqual(t, data1, data2)
+	assert.Len(t, data1, 16+16)
+	text, err := random.Decode(data1, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+
+	_, err = NewAESEncrypt("ofb").Encode([]byte("123456"), key)
+	assert.Equal(t, ErrAESNotFound, err)
+}
+
+func TestAESFixedIV(t *testing.T) {
+	key := []byte("1234567812345678")
+	encrypt := NewAESEncrypt(CBC, WithIV([]byte("1234567890ABCDEF")))
+	data, err := encrypt.Encode([]byte("123456"), key)
+	assert.Nil(t, err)
+	// 与AesEncrypt使用相同的固定IV, 密文中不包含IV
+	expected, _ := AesEncrypt([]byte("123456"), key)
+	assert.Equal(t, expected, data)
+	// CBC默认使用相同的固定IV, 与已经部署的桩兼容
+	data, err = NewAESEncrypt(CBC).Encode([]byte("123456"), key)
+	assert.Nil(t, err)
+	assert.Equal(t, expected, data)
+	text, err := encrypt.Decode(data, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+	// AesDecrypt使用密钥作为IV
+	data, err = NewAESEncrypt(CBC, WithIV(key)).Encode([]byte("123456"), key)
+	assert.Nil(t, err)
+	text, err = AesDecrypt(data, key)
+	assert.Nil(t, err)
+	assert.Equal(t, "123456", string(text))
+
+	// GCM的nonce为12字节
+	_, err = NewAESEncrypt(GCM, WithIV([]byte("1234567890ABCDEF"))).Encode([]byte("123456"), key)
+	assert.Equal(t, ErrIVSize, err)
+	data, err = NewAESEncrypt(GCM, WithIV([]byte("1234567890AB"))).Encode([]byte("123456"), key)
+	assert.Nil(t, err)
+	assert.Len(t, data, 6+16)
+}
+
+func TestAESDecodeInvalid(t *testing.T) {
+	key := []byte("1234567812345678")
+	for _, mode := range []AESMode{CBC, ECB, GCM} {
+		encrypt := NewAESEncrypt(mode)
+		data, err := encrypt.Encode([]byte("123456"), key)
+		assert.Nil(t, err)
+
+		_, err = encrypt.Decode(data[:len(data)-1], key)
+		assert.True(t, errors.Is(err, ErrCiphertext), mode)
+		_, err = encrypt.Decode(nil, key)
+		assert.True(t, errors.Is(err, ErrCiphertext), mode)
+		// 错误的密钥导致填充错误或者认证失败
+		_, err = encrypt.Decode(data, []byte("8765432187654321"))
+		assert.True(t, err == nil || errors.Is(err, ErrCiphertext), mode)
+	}
+
+	encrypt := NewAESEncrypt(GCM)
+	data, _ := encrypt.Encode([]byte("123456"), key)
+	data[len(data)-1] ^= 0xff
+	_, err := encrypt.Decode(data, key)
+	assert.True(t, errors.Is(err, ErrCiphertext))
 }
 
 func TestCBCEncrypt2(t *testing.T) {
@@ -51,3 +146,66 @@ func TestECBEncrypt(t *testing.T) {
 	assert.Equal(t, text, string(text2))
 	fmt.Println(text2)
 }
+
+func TestTripleEncrypt(t *testing.T) {
+	text := "123456"
+	key := []byte("0123456789ABCDEF01234567")
+	encrypt := TripAES()
+	data, err := encrypt.Encode([]byte(text), key)
+	assert.Nil(t, err)
+	text2, err := encrypt.Decode(data, key)
+	assert.Nil(t, err)
+	assert.Equal(t, text, string(text2))
+
+	_, err = encrypt.Decode(data[:len(data)-1], key)
+	assert.Equal(t, ErrBlockSize, err)
+}
+
+func TestPKCS5UnPadding(t *testing.T) {
+	assert.Equal(t, []byte{1, 2, 3}, PKCS5UnPadding([]byte{1, 2, 3, 2, 2}))
+	assert.Nil(t, PKCS5UnPadding(nil))
+	assert.Nil(t, PKCS5UnPadding([]byte{1, 2, 9}))
+	assert.Nil(t, PKCS5UnPadding([]byte{1, 3, 2}))
+}
+
+// FuzzDecode 桩上传的任意密文都不能导致Decode panic
+func FuzzDecode(f *testing.F) {
+	aesKey := []byte("1234567812345678")
+	desKey := []byte("12345678")
+	tripleKey := []byte("0123456789ABCDEF01234567")
+	sm2Key, _ := sm2.GenerateKey(nil)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	decoders := []struct {
+		encrypt Encrypt
+		key     []byte
+	}{
+		{NewAESEncrypt(CBC), aesKey},
+		{NewAESEncrypt(CFB), aesKey},
+		{NewAESEncrypt(CTR), aesKey},
+		{NewAESEncrypt(ECB), aesKey},
+		{NewAESEncrypt(GCM), aesKey},
+		{NewAESEncrypt(CBC, WithRandomIV()), aesKey},
+		{TripAES(), tripleKey},
+		{NewCBCEncrypt(), desKey},
+		{NewECBEncrypt(), desKey},
+		{NewSM4Encrypt(CBC), aesKey},
+		{NewSM4Encrypt(ECB), aesKey},
+		{NewSM2Encrypt(WithSM2PrivateKey(sm2Key)), nil},
+		{NewSM2Encrypt(WithSM2Mode(SM2C1C2C3), WithSM2PrivateKey(sm2Key)), nil},
+		{NewRSAEncrypt(OAEP, WithRSAPrivateKey(rsaKey)), nil},
+		{NewRSAEncrypt(PKCS1v15, WithRSAPrivateKey(rsaKey)), nil},
+	}
+	f.Add([]byte{})
+	f.Add([]byte("0123456789ABCDEF"))
+	for _, decoder := range decoders {
+		data, _ := decoder.encrypt.Encode([]byte("123456"), decoder.key)
+		f.Add(data)
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, decoder := range decoders {
+			_, _ = decoder.encrypt.Decode(data, decoder.key)
+		}
+		_, _ = AesDecrypt(data, aesKey)
+		_ = PKCS5UnPadding(data)
+	})
+}
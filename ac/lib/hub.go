@@ -2,18 +2,25 @@ package lib
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Kotodian/gokit/datasource"
 
+	"github.com/Kotodian/gokit/ac/capture"
+	"github.com/Kotodian/gokit/ac/spool"
 	"github.com/Kotodian/gokit/datasource/mqtt"
 	"github.com/Kotodian/gokit/sync/errgroup.v2"
 	"github.com/Kotodian/gokit/workpool"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
 	mqttClient "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the
@@ -24,7 +31,11 @@ type Hub struct {
 	Protocol string
 	// ProtocolVersion 协议版本
 	ProtocolVersion string
-	// MqttClient MQTT连接
+	// Transport 与平台之间的消息通道
+	Transport Transport
+	// MqttClient MQTT连接, 只有使用MQTTTransport时不为空
+	//
+	// Deprecated: 使用Transport
 	MqttClient *mqtt.MQTTClient
 
 	// PubMqttMsg 发送到MQTT的信息通道
@@ -32,6 +43,8 @@ type Hub struct {
 
 	// TR 协议翻译, 把协议的内容发到core-gw
 	TR ITranslate //协议翻译器
+	// Middlewares 包装TR的中间件, 通过Use添加
+	Middlewares []Middleware
 
 	// CommandFn 发送函数
 	CommandFn func(ctx context.Context, payload interface{}) ([]byte, error)
@@ -48,45 +61,264 @@ type Hub struct {
 	RegClients sync.Map
 	// Encrypt 加密报文
 	Encrypt Encrypt
+	// MaxInflight 大于0时同一个桩上传的报文按顺序处理, 排队超过MaxInflight条时暂停读取
+	MaxInflight int
+	// CommandTimeout 大于0时下发给桩的请求超时未回复, 会回复平台错误
+	CommandTimeout time.Duration
+	// Recorder 不为空时可以按桩的sn抓包
+	Recorder *capture.Recorder
+	// Cluster 不为空时开启集群模式
+	Cluster *Cluster
+	// Spool 不为空时, MQTT不可用或者发送失败的消息写入磁盘, 重连后按顺序补发
+	Spool *spool.Spool
+	// Authenticator 桩建立连接时的认证
+	Authenticator Authenticator
+	// DuplicateFn 同一个桩重复连接时调用, old已经用ErrDuplicateConnection关闭
+	DuplicateFn func(old, new ClientInterface)
+	// Log 网关的日志, 为空时不输出
+	Log *zap.Logger
+
+	// closing 正在关闭, 不再接受新的注册
+	closing int32
+	// running Run是否已经启动
+	running int32
+	// ctx Run的上下文, cancel 停止Run
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done Run退出时关闭
+	done chan struct{}
+	// publishing 正在发送到MQTT的消息
+	publishing sync.WaitGroup
+	// publishErrors 发送到MQTT失败的次数
+	publishErrors uint64
+	// translateErrors 协议翻译失败的次数
+	translateErrors uint64
+	// duplicates 同一个桩重复连接的次数
+	duplicates uint64
+	// authFailures 桩认证失败的次数
+	authFailures uint64
+	// translator 使用Middlewares包装后的TR
+	translator ITranslate
+	// forwards 集群模式下等待转发到其他网关的消息
+	forwards chan forwardMessage
 }
 
+// ErrShutdown 网关关闭时断开客户端的原因
+var ErrShutdown = errors.New("网关关闭")
+
+// ErrDuplicateConnection 同一个桩建立了新的连接, 断开旧连接的原因
+var ErrDuplicateConnection = errors.New("桩重复连接")
+
+const (
+	// publishTimeout 发送一条消息到平台的超时时间
+	publishTimeout = 3 * time.Second
+	// subscribeTimeout 订阅以及取消订阅的超时时间
+	subscribeTimeout = 10 * time.Second
+)
+
+// NewHub 连接到环境变量EMQX_POOL指定的MQTT服务器, 连接失败时panic, 新代码应该使用NewHubWithOptions
 func NewHub(protocol string, protocolVersion, username string, password string) *Hub {
-	//监听MQTT信息
-	hostname, _ := os.Hostname()
-	options := mqtt.NewMQTTOptions(hostname, username, password, func(c mqttClient.Client) {
-		// logrus.Info("mqtt connected")
-	}, func(c mqttClient.Client, err error) {
-		// logrus.Errorf("disconnect mqtt error, err:%s", err.Error())
-		c.Publish("coregw/disconnect/"+hostname, 2, false, "")
-	}, func(c mqttClient.Client, m mqttClient.Message) {
-		// logrus.Warnf("got mqtt unhandled msg:%v", m)
-	}, false)
-	// 设置遗愿消息
-	options = options.SetWill("coregw/disconnect/"+hostname, "", 2, false)
-	mqClient := mqtt.NewMQTTClient(options)
-	//connect
-	if err := mqClient.Connect(); err != nil {
-		panic(fmt.Sprintf("connect to mqtt error, err:%s", err.Error()))
+	hub, err := NewHubWithOptions(protocol, protocolVersion, WithMQTTAuth(username, password))
+	if err != nil {
+		panic(err.Error())
+	}
+	return hub
+}
+
+type hubOptions struct {
+	hostname  string
+	transport Transport
+	broker    string
+	username  string
+	password  string
+}
+
+type HubOption func(*hubOptions)
+
+// WithHostname 网关的名称, 用作MQTT的clientID以及topic的前缀, 默认为主机名
+func WithHostname(hostname string) HubOption {
+	return func(o *hubOptions) {
+		o.hostname = hostname
 	}
+}
 
+// WithTransport 使用已经连接的Transport, 设置后忽略MQTT相关的选项
+func WithTransport(transport Transport) HubOption {
+	return func(o *hubOptions) {
+		o.transport = transport
+	}
+}
+
+// WithMQTTBroker MQTT服务器的地址, 默认读取环境变量EMQX_POOL
+func WithMQTTBroker(broker string) HubOption {
+	return func(o *hubOptions) {
+		o.broker = broker
+	}
+}
+
+// WithMQTTAuth MQTT的用户名以及密码
+func WithMQTTAuth(username, password string) HubOption {
+	return func(o *hubOptions) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// NewHubWithOptions 创建网关, 没有指定Transport时连接MQTT服务器, 连接失败返回错误
+func NewHubWithOptions(protocol string, protocolVersion string, opts ...HubOption) (*Hub, error) {
+	o := new(hubOptions)
+	for _, optFunc := range opts {
+		optFunc(o)
+	}
+	if o.hostname == "" {
+		o.hostname, _ = os.Hostname()
+	}
 	hub := &Hub{
-		Hostname:        hostname,
-		MqttClient:      mqClient,
+		Hostname:        o.hostname,
+		Transport:       o.transport,
 		PubMqttMsg:      make(chan mqtt.MqttMessage, 1000),
 		Protocol:        protocol,
 		ProtocolVersion: protocolVersion,
+		done:            make(chan struct{}),
 	}
-	return hub
+	if hub.Transport == nil {
+		transport, err := newMQTTTransport(o)
+		if err != nil {
+			return nil, err
+		}
+		hub.Transport = transport
+	}
+	if t, ok := hub.Transport.(*MQTTTransport); ok {
+		hub.MqttClient = t.Client()
+	}
+	hub.Transport.OnDisconnect(func(err error) {
+		// 连接断开时通知平台, 重连后由平台处理
+		go func() {
+			_ = hub.publishMQTT(mqtt.MqttMessage{Topic: "coregw/disconnect/" + hub.Hostname, Qos: 2})
+		}()
+	})
+	hub.ctx, hub.cancel = context.WithCancel(context.Background())
+	return hub, nil
+}
+
+func newMQTTTransport(o *hubOptions) (*MQTTTransport, error) {
+	//监听MQTT信息
+	options := mqtt.NewMQTTOptions(o.hostname, o.username, o.password, func(c mqttClient.Client) {
+		// logrus.Info("mqtt connected")
+	}, nil, func(c mqttClient.Client, m mqttClient.Message) {
+		// logrus.Warnf("got mqtt unhandled msg:%v", m)
+	}, false)
+	if o.broker != "" {
+		options.Servers = nil
+		options.AddBroker(o.broker)
+	}
+	// 设置遗愿消息
+	options = options.SetWill("coregw/disconnect/"+o.hostname, "", 2, false)
+	transport := NewMQTTTransport(options)
+	//connect
+	if err := transport.Connect(); err != nil {
+		return nil, fmt.Errorf("connect to mqtt error, err:%s", err.Error())
+	}
+	return transport, nil
 }
 
 func (h *Hub) SetTR(tr ITranslate) {
 	h.TR = tr
+	h.translator = Chain(tr, h.Middlewares...)
 }
 
 func (h *Hub) SetEncrypt(encrypt Encrypt) {
 	h.Encrypt = encrypt
 }
 
+func (h *Hub) SetMaxInflight(maxInflight int) {
+	h.MaxInflight = maxInflight
+}
+
+func (h *Hub) SetCommandTimeout(timeout time.Duration) {
+	h.CommandTimeout = timeout
+}
+
+func (h *Hub) SetSpool(s *spool.Spool) {
+	h.Spool = s
+}
+
+func (h *Hub) SetRecorder(recorder *capture.Recorder) {
+	h.Recorder = recorder
+}
+
+// Capture 记录桩的报文, 没有设置Recorder或者该桩未开启抓包时忽略
+func (h *Hub) Capture(sn string, kind capture.Kind, topic string, data []byte) {
+	if h.Recorder != nil {
+		h.Recorder.Record(sn, kind, topic, data)
+	}
+}
+
+// ErrCommandTimeout 下发给桩的请求超时未回复, 协议中没有超时的错误码, 回复平台EC_GenericError并在Description中说明
+var ErrCommandTimeout = errors.New("command timeout")
+
+// TrackCommand 记录下发给桩的请求, 超时或者连接断开时回复平台EC_GenericError
+// 需要在发送之前调用, 避免桩的回复比记录先到; 发送失败时调用UntrackCommand, 客户端没有实现ClientMonitor时忽略
+func (h *Hub) TrackCommand(client ClientInterface, topic string, apdu *charger.APDU) {
+	pending, ok := h.pending(client)
+	if !ok || !apdu.IsRequest() || apdu.NoNeedReply {
+		return
+	}
+	sequenceID := apdu.SequenceId
+	description := fmt.Sprintf("%s: %s", apdu.Action(), ErrCommandTimeout)
+	pending.Add(sequenceID, h.CommandTimeout, func() {
+		payload, _ := proto.Marshal(&charger.MessageError{
+			Error:       charger.ErrorCode_EC_GenericError,
+			Description: description,
+		})
+		apduEncoded, _ := proto.Marshal(&charger.APDU{
+			Timestamp:  int32(time.Now().Unix()),
+			SequenceId: sequenceID,
+			MessageId:  charger.MessageID_ID_MessageError,
+			Payload:    payload,
+		})
+		m := mqtt.MqttMessage{
+			Topic:    strings.Replace(topic, h.Hostname, "coregw", 1),
+			Qos:      2,
+			Retained: false,
+			Payload:  apduEncoded,
+		}
+		h.Capture(client.ChargeStation().SN(), capture.KindMQTTOut, m.Topic, m.Payload)
+		h.PubMqttMsg <- m
+	})
+}
+
+// UntrackCommand 请求没有发送到桩, 取消记录
+func (h *Hub) UntrackCommand(client ClientInterface, apdu *charger.APDU) {
+	pending, ok := h.pending(client)
+	if !ok || !apdu.IsRequest() || apdu.NoNeedReply {
+		return
+	}
+	pending.Resolve(apdu.SequenceId)
+}
+
+// ResolveCommand 桩回复了平台的请求
+func (h *Hub) ResolveCommand(client ClientInterface, apdu *charger.APDU) {
+	if apdu == nil || apdu.IsRequest() {
+		return
+	}
+	if monitor, ok := client.(ClientMonitor); ok {
+		monitor.Pending().Resolve(apdu.SequenceId)
+	}
+}
+
+// pending 开启了命令超时并且客户端实现了ClientMonitor时返回客户端的请求记录
+func (h *Hub) pending(client ClientInterface) (*PendingRequests, bool) {
+	if h.CommandTimeout <= 0 {
+		return nil, false
+	}
+	monitor, ok := client.(ClientMonitor)
+	if !ok {
+		return nil, false
+	}
+	return monitor.Pending(), true
+}
+
 func (h *Hub) SendMsgToDevice(evse interface{}, msg []byte) error {
 	if c, ok := h.Clients.Load(evse); ok {
 		return c.(ClientInterface).Send(msg)
@@ -94,24 +326,102 @@ func (h *Hub) SendMsgToDevice(evse interface{}, msg []byte) error {
 	return fmt.Errorf("sn:%s offline", evse)
 }
 
+// Kick 踢掉设备, 返回设备是否在线
+func (h *Hub) Kick(coreID uint64) bool {
+	c, ok := h.Clients.Load(coreID)
+	if !ok {
+		// logrus.Warnf("kick client not found, core id:%d", coreID)
+		return false
+	}
+	_ = c.(ClientInterface).Close(nil)
+	return true
+}
+
 // CloseClient 断开连接
 func (h *Hub) CloseClient(evse interface{}) {
 	h.Clients.Delete(evse)
 }
 
+func (h *Hub) SetDuplicateFunc(f func(old, new ClientInterface)) {
+	h.DuplicateFn = f
+}
+
+func (h *Hub) SetLog(log *zap.Logger) {
+	h.Log = log
+}
+
+func (h *Hub) logger() *zap.Logger {
+	if h.Log == nil {
+		return zap.NewNop()
+	}
+	return h.Log
+}
+
+// AddClient 记录注册完成的客户端, 同一个桩已经有其他客户端时关闭旧的客户端
+func (h *Hub) AddClient(client ClientInterface) {
+	h.addClient(&h.Clients, client)
+}
+
+// AddRegClient 记录需要执行注册的客户端, 同一个桩已经有其他客户端时关闭旧的客户端
+func (h *Hub) AddRegClient(client ClientInterface) {
+	h.addClient(&h.RegClients, client)
+}
+
+// RemoveClient 客户端关闭时调用, 只删除client自己, 不会删除同一个桩新建立的客户端
+func (h *Hub) RemoveClient(client ClientInterface) {
+	coreID := client.ChargeStation().CoreID()
+	h.Clients.CompareAndDelete(coreID, client)
+	h.RegClients.CompareAndDelete(coreID, client)
+}
+
+func (h *Hub) addClient(clients *sync.Map, client ClientInterface) {
+	old, loaded := clients.Swap(client.ChargeStation().CoreID(), client)
+	if !loaded || old == client {
+		return
+	}
+	h.closeDuplicate(old.(ClientInterface), client)
+}
+
+// closeDuplicate 桩在旧连接超时之前重新连接, 在新的goroutine中关闭旧的客户端, 不阻塞新客户端的注册
+func (h *Hub) closeDuplicate(old, client ClientInterface) {
+	atomic.AddUint64(&h.duplicates, 1)
+	h.logger().Warn("duplicate connection, close the old one",
+		zap.Uint64("coreId", client.ChargeStation().CoreID()),
+		zap.String("old", old.RemoteAddress()),
+		zap.String("new", client.RemoteAddress()))
+	go func() {
+		_ = old.Close(ErrDuplicateConnection)
+		if h.DuplicateFn != nil {
+			h.DuplicateFn(old, client)
+		}
+	}()
+}
+
 func (h *Hub) Run() {
-	_, cancelFn := context.WithCancel(context.Background())
-	g := errgroup.WithCancel(context.Background())
-	defer cancelFn()
+	if !atomic.CompareAndSwapInt32(&h.running, 0, 1) {
+		return
+	}
+	defer close(h.done)
+	g := errgroup.WithCancel(h.ctx)
+	defer h.cancel()
 	topicPrefix := h.Hostname + "/"
 	topicEnd := "/#"
+	// 集群模式下查询桩所在的网关需要访问Registry, 在单独的goroutine中转发
+	if h.Cluster != nil {
+		h.forwards = make(chan forwardMessage, forwardQueueSize)
+		for i := 0; i < forwardWorkers; i++ {
+			g.Go(h.runForward)
+		}
+	}
 	//监听注册报文
 	g.Go(func(ctx context.Context) (err error) {
-		token := h.MqttClient.GetMQTT().Subscribe(topicPrefix+"register"+topicEnd, 2, func(mqc mqttClient.Client, m mqttClient.Message) {
-			topic := m.Topic()
-			_, coreID := getCoreIDFromTopic(m.Topic())
+		err = h.Transport.Subscribe(map[string]byte{topicPrefix + "register" + topicEnd: 2}, func(m mqtt.MqttMessage) {
+			_, coreID := getCoreIDFromTopic(m.Topic)
 
 			var _client ClientInterface
+			if h.IsClosing() {
+				return
+			}
 			if c, ok := h.RegClients.Load(coreID); !ok {
 				return
 			} else {
@@ -120,15 +430,9 @@ func (h *Hub) Run() {
 
 			fmt.Println("go reg mqtt client", fmt.Sprintf("%+v", _client))
 
-			_client.PublishReg(mqtt.MqttMessage{
-				Topic:    topic,
-				Payload:  m.Payload(),
-				Qos:      m.Qos(),
-				Retained: m.Retained(),
-			})
+			_client.PublishReg(m)
 		})
-		token.WaitTimeout(10 * time.Second)
-		if err = token.Error(); err != nil {
+		if err != nil {
 			return fmt.Errorf("sub reg cmd chan error, err:%v", err.Error())
 		}
 		return
@@ -141,9 +445,9 @@ func (h *Hub) Run() {
 			topicPrefix + "telemetry" + topicEnd: 2,
 		}
 
-		token := h.MqttClient.GetMQTT().SubscribeMultiple(topics, func(mqc mqttClient.Client, m mqttClient.Message) {
+		handler := func(m mqtt.MqttMessage) {
 			var err error
-			topic := m.Topic()
+			topic := m.Topic
 
 			defer func() {
 				if err != nil {
@@ -159,6 +463,9 @@ func (h *Hub) Run() {
 
 			var _client ClientInterface
 			if !ok {
+				if h.forward(m, coreID) {
+					return
+				}
 				fmt.Printf("chargingStation:%d offline", coreID)
 				return
 			} else {
@@ -169,15 +476,12 @@ func (h *Hub) Run() {
 			}
 			//fmt.Println("go mqtt msg client", fmt.Sprintf("%+v", _client))
 
-			_client.Publish(mqtt.MqttMessage{
-				Topic:    topic,
-				Payload:  m.Payload(),
-				Qos:      m.Qos(),
-				Retained: m.Retained(),
-			})
-		})
-		token.WaitTimeout(time.Second * 5)
-		if err = token.Error(); err != nil {
+			_client.Publish(m)
+		}
+		if err = h.Transport.Subscribe(topics, handler); err == nil {
+			err = h.subscribeShared(handler, "command", "telemetry")
+		}
+		if err != nil {
 			return fmt.Errorf("sub core cmd chan error, err:%v", err.Error())
 		}
 		return
@@ -191,46 +495,60 @@ func (h *Hub) Run() {
 		for {
 			select {
 			case <-ctx.Done():
-				return nil
+				// 发送剩余的消息后再退出
+				for {
+					select {
+					case m := <-h.PubMqttMsg:
+						h.publishing.Add(1)
+						h.publish(m)
+					default:
+						h.publishing.Wait()
+						go h.drainLate()
+						return nil
+					}
+				}
 			case m := <-h.PubMqttMsg:
+				h.publishing.Add(1)
 				wp.PushTaskFunc(func(w *workpool.WorkPool, args ...interface{}) workpool.Flag {
-					token := h.MqttClient.GetMQTT().Publish(
-						m.Topic,
-						m.Qos,
-						m.Retained,
-						m.Payload,
-					)
-					token.WaitTimeout(time.Second * 3)
-					if err := token.Error(); err != nil {
-						// logrus.Errorf("pub msg to topic:%s error, %s", m.Topic, err.Error())
-					}
+					h.publish(m)
 					return workpool.FLAG_OK
 				})
 			}
 		}
 	})
+	// 补发缓存在磁盘上的消息
+	if h.Spool != nil {
+		g.Go(func(ctx context.Context) error {
+			ticker := time.NewTicker(time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-ticker.C:
+					h.replay(ctx)
+				}
+			}
+		})
+	}
 	// 监听踢掉设备的报文
 	g.Go(func(ctx context.Context) error {
-		token := h.MqttClient.GetMQTT().Subscribe(topicPrefix+"kick"+topicEnd, 2, func(mqc mqttClient.Client, message mqttClient.Message) {
+		handler := func(m mqtt.MqttMessage) {
 
 			//根据topic获取sn
 			var coreID uint64
 
-			_, coreID = getCoreIDFromTopic(message.Topic())
+			_, coreID = getCoreIDFromTopic(m.Topic)
 
-			c, ok := h.Clients.Load(coreID)
-
-			var _client ClientInterface
-			if !ok {
-				// logrus.Warnf("kick client not found, topic:%s", message.Topic())
-				return
-			} else {
-				_client = c.(ClientInterface)
-				_client.Close(nil)
+			if !h.Kick(coreID) {
+				h.forward(m, coreID)
 			}
-		})
-		token.WaitTimeout(10 * time.Second)
-		if err := token.Error(); err != nil {
+		}
+		err := h.Transport.Subscribe(map[string]byte{topicPrefix + "kick" + topicEnd: 2}, handler)
+		if err == nil {
+			err = h.subscribeShared(handler, "kick")
+		}
+		if err != nil {
 			return fmt.Errorf("sub reg cmd chan error, err:%v", err.Error())
 		}
 		return nil
@@ -238,6 +556,140 @@ func (h *Hub) Run() {
 	_ = g.Wait()
 }
 
+func (h *Hub) publish(m mqtt.MqttMessage) {
+	defer h.publishing.Done()
+	// 磁盘上还有没补发的消息时, 新的消息也写入磁盘, 保证平台收到的顺序
+	if h.Spool != nil && (!h.Transport.IsConnected() || !h.Spool.Empty()) {
+		h.spool(m)
+		return
+	}
+	if err := h.publishMQTT(m); err != nil {
+		if h.Spool != nil {
+			h.spool(m)
+			return
+		}
+		atomic.AddUint64(&h.publishErrors, 1)
+	}
+}
+
+func (h *Hub) publishMQTT(m mqtt.MqttMessage) error {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	return h.Transport.Publish(ctx, m)
+}
+
+// spool 写入磁盘, 磁盘已满时丢弃
+func (h *Hub) spool(m mqtt.MqttMessage) {
+	if err := h.Spool.Append(m); err != nil {
+		atomic.AddUint64(&h.publishErrors, 1)
+		h.logger().Error("spool mqtt message error, err:"+err.Error(), zap.String("topic", m.Topic))
+	}
+}
+
+// drainLate Run退出后仍然有客户端发送消息时写入磁盘, 没有Spool时丢弃, 避免发送方一直阻塞
+func (h *Hub) drainLate() {
+	for m := range h.PubMqttMsg {
+		if h.Spool != nil {
+			h.spool(m)
+		} else {
+			atomic.AddUint64(&h.publishErrors, 1)
+		}
+	}
+}
+
+// replay MQTT连接正常时按顺序补发磁盘上的消息, 发送成功后才会删除
+func (h *Hub) replay(ctx context.Context) {
+	for ctx.Err() == nil && h.Transport.IsConnected() {
+		m, err := h.Spool.Peek()
+		if err != nil {
+			return
+		}
+		if err = h.publishMQTT(m); err != nil {
+			return
+		}
+		if err = h.Spool.Ack(); err != nil {
+			return
+		}
+	}
+}
+
+// IsClosing 网关是否正在关闭, 关闭时不应该再接受新的连接
+func (h *Hub) IsClosing() bool {
+	return atomic.LoadInt32(&h.closing) == 1
+}
+
+// Shutdown 平滑关闭网关
+// 取消订阅平台的消息, 断开所有的客户端, 等待客户端全部下线后发送完剩余的消息,
+// 最后通知平台该网关已经下线, ctx超时后不再等待
+func (h *Hub) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&h.closing, 0, 1) {
+		return errors.New("hub is closing")
+	}
+	topicPrefix := h.Hostname + "/"
+	topicEnd := "/#"
+	topics := []string{
+		topicPrefix + "register" + topicEnd,
+		topicPrefix + "command" + topicEnd,
+		topicPrefix + "telemetry" + topicEnd,
+		topicPrefix + "kick" + topicEnd,
+	}
+	for filter := range h.sharedFilters("command", "telemetry", "kick") {
+		topics = append(topics, filter)
+	}
+	_ = h.Transport.Unsubscribe(topics...)
+
+	closeClients := func(key, value interface{}) bool {
+		go value.(ClientInterface).Close(ErrShutdown)
+		return true
+	}
+	h.Clients.Range(closeClients)
+	h.RegClients.Range(closeClients)
+
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	var err error
+wait:
+	for h.clientCount() > 0 {
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			break wait
+		case <-ticker.C:
+		}
+	}
+
+	// 停止Run并等待剩余的消息发送完
+	h.cancel()
+	if atomic.LoadInt32(&h.running) == 1 {
+		select {
+		case <-h.done:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+
+	e := h.publishMQTT(mqtt.MqttMessage{
+		Topic:   "coregw/disconnect/" + h.Hostname,
+		Qos:     2,
+		Payload: []byte("shutdown"),
+	})
+	if err == nil {
+		err = e
+	}
+	return err
+}
+
+func (h *Hub) clientCount() int {
+	count := 0
+	counter := func(key, value interface{}) bool {
+		count++
+		return true
+	}
+	h.Clients.Range(counter)
+	h.RegClients.Range(counter)
+	return count
+}
+
 func getCoreIDFromTopic(topic string) (coregw string, coreID uint64) {
 	//根据topic获取sn
 	topics := strings.Split(topic, "/")
@@ -0,0 +1,47 @@
+package lib
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingClient Close一直阻塞到release关闭
+type blockingClient struct {
+	ClientInterface
+	release chan struct{}
+	closed  chan error
+}
+
+func (c *blockingClient) Close(err error) error {
+	<-c.release
+	c.closed <- err
+	return nil
+}
+
+func TestCloseDuplicate(t *testing.T) {
+	hub := &Hub{}
+	old := &blockingClient{ClientInterface: NewTestClient(), release: make(chan struct{}), closed: make(chan error, 1)}
+	hub.AddClient(old)
+
+	// 关闭旧的客户端阻塞时不影响新客户端的注册
+	client := NewTestClient()
+	added := make(chan struct{})
+	go func() {
+		hub.AddClient(client)
+		close(added)
+	}()
+	select {
+	case <-added:
+	case <-time.After(time.Second):
+		t.Fatal("AddClient blocked on closing the old client")
+	}
+	current, _ := hub.Clients.Load(client.ChargeStation().CoreID())
+	assert.Equal(t, client, current)
+	assert.Equal(t, uint64(1), hub.Stats().Duplicates)
+
+	close(old.release)
+	assert.True(t, errors.Is(<-old.closed, ErrDuplicateConnection))
+}
@@ -80,15 +80,25 @@ type ClientInterface interface {
 	BaseURL() string
 }
 
+// ClientMonitor 客户端可选实现的接口, 用于命令超时以及统计, tcp和websocket客户端都实现了该接口
+type ClientMonitor interface {
+	// Pending 平台下发给桩、尚未收到回复的请求
+	Pending() *PendingRequests
+	// Stats 客户端的统计信息
+	Stats() ClientStats
+}
+
 type testClient struct {
 	chargingStation interfaces.ChargeStation
 	encrypt         Encrypt
 	encryptKey      []byte
+	pending         *PendingRequests
 } // Send 直接发送消息
 
 func NewTestClient() ClientInterface {
 	return &testClient{
 		chargingStation: interfaces.NewDefaultChargeStation("test", true, 0),
+		pending:         NewPendingRequests(),
 	}
 }
 func (*testClient) Send(msg []byte) error {
@@ -278,3 +288,16 @@ func (t *testClient) SetBaseURL(_ string) {
 func (t *testClient) BaseURL() string {
 	return "jxcsmsuat.joysonquin.com"
 }
+
+func (t *testClient) Pending() *PendingRequests {
+	return t.pending
+}
+
+func (t *testClient) Stats() ClientStats {
+	return ClientStats{
+		SN:            t.chargingStation.SN(),
+		CoreID:        t.chargingStation.CoreID(),
+		RemoteAddress: t.RemoteAddress(),
+		Pending:       t.pending.Len(),
+	}
+}
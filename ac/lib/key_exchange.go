@@ -0,0 +1,111 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+)
+
+// ErrSessionKey 桩上传的会话密钥长度错误
+var ErrSessionKey = errors.New("invalid session key")
+
+// KeyExchange 桩使用平台的公钥(RSA或者SM2)加密随机生成的会话密钥后上传,
+// 平台解密后设置为桩的密钥, 之后的报文使用Session加密
+type KeyExchange struct {
+	// Decrypt 解密会话密钥, 一般为配置了私钥的NewRSAEncrypt或者NewSM2Encrypt
+	Decrypt Encrypt
+	// PrivateKey 解密使用的私钥, 为空时使用Decrypt中配置的私钥
+	PrivateKey []byte
+	// Session 交换完成后报文使用的加密方式, 为空时不改变桩的加密方式
+	Session Encrypt
+	// KeySizes 会话密钥允许的长度, 为空时不检查
+	KeySizes []int
+}
+
+// NewKeyExchange session为交换完成后使用的加密方式, 会话密钥的长度为keySizes之一
+func NewKeyExchange(decrypt Encrypt, session Encrypt, keySizes ...int) *KeyExchange {
+	return &KeyExchange{Decrypt: decrypt, Session: session, KeySizes: keySizes}
+}
+
+// Exchange 解密桩上传的会话密钥并立即切换, 返回会话密钥,
+// 之后发送的报文(包括对密钥交换的回复)都使用会话密钥加密;
+// 回复需要使用原来的密钥时先调用Open, 回复之后再调用Apply
+func (k *KeyExchange) Exchange(client ClientInterface, encrypted []byte) ([]byte, error) {
+	key, err := k.Open(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	k.Apply(client, key)
+	return key, nil
+}
+
+// Open 解密桩上传的会话密钥, 不改变桩的密钥
+//
+// RSA PKCS1v15时KeySizes必须只有一个长度, 填充错误时不返回错误而是返回随机的密钥,
+// 避免回复的差异成为Bleichenbacher攻击的oracle, 之后使用错误密钥的报文无法解密
+func (k *KeyExchange) Open(encrypted []byte) ([]byte, error) {
+	if r, ok := k.Decrypt.(*rsaEncrypt); ok && r.padding == PKCS1v15 {
+		return k.openPKCS1v15(r, encrypted)
+	}
+	key, err := k.Decrypt.Decode(encrypted, k.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt session key error, err:%w", err)
+	}
+	if !k.validSize(len(key)) {
+		return nil, fmt.Errorf("%w: length %d", ErrSessionKey, len(key))
+	}
+	return key, nil
+}
+
+// Apply 调用client的SetEncryptKey以及SetEncrypt, Send在调用时加密, 在Apply之前调用的Send使用原来的密钥
+func (k *KeyExchange) Apply(client ClientInterface, key []byte) {
+	client.SetEncryptKey(string(key))
+	if k.Session != nil {
+		client.SetEncrypt(k.Session)
+	}
+}
+
+// ExchangeCtx 在协议翻译中使用, 从ctx中获取桩
+func (k *KeyExchange) ExchangeCtx(ctx context.Context, encrypted []byte) ([]byte, error) {
+	client, ok := ClientFromCtx(ctx)
+	if !ok {
+		return nil, errors.New("client not found in context")
+	}
+	return k.Exchange(client, encrypted)
+}
+
+func (k *KeyExchange) openPKCS1v15(r *rsaEncrypt, encrypted []byte) ([]byte, error) {
+	if len(k.KeySizes) != 1 {
+		return nil, fmt.Errorf("%w: pkcs1v15 requires exactly one key size", ErrSessionKey)
+	}
+	privateKey, err := r.private(k.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(encrypted) != privateKey.Size() {
+		return nil, fmt.Errorf("%w: length %d", ErrCiphertext, len(encrypted))
+	}
+	key := make([]byte, k.KeySizes[0])
+	if _, err = rand.Read(key); err != nil {
+		return nil, err
+	}
+	// 只有密文长度错误时返回错误, 与填充是否正确无关
+	if err = rsa.DecryptPKCS1v15SessionKey(rand.Reader, privateKey, encrypted, key); err != nil {
+		return nil, fmt.Errorf("decrypt session key error, err:%w", err)
+	}
+	return key, nil
+}
+
+func (k *KeyExchange) validSize(size int) bool {
+	if len(k.KeySizes) == 0 {
+		return size > 0
+	}
+	for _, s := range k.KeySizes {
+		if s == size {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+	"go.uber.org/zap"
+)
+
+// ToAPDUFunc 与ITranslate.ToAPDU相同
+type ToAPDUFunc func(ctx context.Context, msg []byte) (proto.Message, error)
+
+// FromAPDUFunc 与ITranslate.FromAPDU相同
+type FromAPDUFunc func(ctx context.Context, apdu *charger.APDU) (interface{}, error)
+
+// Middleware 包装协议翻译, 与http的中间件类似, 为空的函数不包装
+type Middleware struct {
+	ToAPDU   func(next ToAPDUFunc) ToAPDUFunc
+	FromAPDU func(next FromAPDUFunc) FromAPDUFunc
+}
+
+// chain 按顺序包装后的协议翻译
+type chain struct {
+	toAPDU   ToAPDUFunc
+	fromAPDU FromAPDUFunc
+}
+
+func (c *chain) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	return c.toAPDU(ctx, msg)
+}
+
+func (c *chain) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	return c.fromAPDU(ctx, apdu)
+}
+
+// Chain 用middlewares包装tr, 第一个中间件在最外层
+func Chain(tr ITranslate, middlewares ...Middleware) ITranslate {
+	if tr == nil || len(middlewares) == 0 {
+		return tr
+	}
+	c := &chain{toAPDU: tr.ToAPDU, fromAPDU: tr.FromAPDU}
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		if middlewares[i].ToAPDU != nil {
+			c.toAPDU = middlewares[i].ToAPDU(c.toAPDU)
+		}
+		if middlewares[i].FromAPDU != nil {
+			c.fromAPDU = middlewares[i].FromAPDU(c.fromAPDU)
+		}
+	}
+	return c
+}
+
+// Use 添加协议翻译的中间件
+func (h *Hub) Use(middlewares ...Middleware) {
+	h.Middlewares = append(h.Middlewares, middlewares...)
+	h.translator = Chain(h.TR, h.Middlewares...)
+}
+
+// ToAPDU 使用中间件包装后的TR翻译桩上传的报文
+func (h *Hub) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	return h.getTranslator().ToAPDU(ctx, msg)
+}
+
+// FromAPDU 使用中间件包装后的TR翻译平台下发的消息
+func (h *Hub) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	return h.getTranslator().FromAPDU(ctx, apdu)
+}
+
+// getTranslator 直接给TR赋值时没有经过SetTR, 每次重新包装
+func (h *Hub) getTranslator() ITranslate {
+	if h.translator != nil {
+		return h.translator
+	}
+	return Chain(h.TR, h.Middlewares...)
+}
+
+// ErrTranslatePanic 协议翻译发生panic
+var ErrTranslatePanic = errors.New("协议翻译panic")
+
+// Recovery 协议翻译发生panic时返回ErrTranslatePanic, 并且把堆栈写入上下文中的日志
+func Recovery() Middleware {
+	recovered := func(ctx context.Context, err *error) {
+		r := recover()
+		if r == nil {
+			return
+		}
+		*err = fmt.Errorf("%w: %v", ErrTranslatePanic, r)
+		if logger, ok := LoggerFromCtx(ctx); ok {
+			logger.Error((*err).Error(), zap.ByteString("stack", debug.Stack()))
+		} else {
+			fmt.Printf("%s\n%s\n", (*err).Error(), debug.Stack())
+		}
+	}
+	return Middleware{
+		ToAPDU: func(next ToAPDUFunc) ToAPDUFunc {
+			return func(ctx context.Context, msg []byte) (to proto.Message, err error) {
+				defer recovered(ctx, &err)
+				return next(ctx, msg)
+			}
+		},
+		FromAPDU: func(next FromAPDUFunc) FromAPDUFunc {
+			return func(ctx context.Context, apdu *charger.APDU) (to interface{}, err error) {
+				defer recovered(ctx, &err)
+				return next(ctx, apdu)
+			}
+		},
+	}
+}
+
+// Logging 记录每次协议翻译的消息id, 耗时以及错误,
+// 优先使用上下文中带有桩信息的日志, 没有时使用logger
+func Logging(logger *zap.Logger) Middleware {
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	log := func(ctx context.Context, msg string, apdu *charger.APDU, start time.Time, err error) {
+		l, ok := LoggerFromCtx(ctx)
+		if !ok {
+			l = logger
+		}
+		fields := []zap.Field{zap.Duration("latency", time.Since(start))}
+		if apdu != nil {
+			fields = append(fields, zap.Stringer("messageId", apdu.MessageId), zap.Uint64("sequenceId", apdu.SequenceId))
+		}
+		if err != nil {
+			l.Error(msg, append(fields, zap.Error(err))...)
+			return
+		}
+		l.Debug(msg, fields...)
+	}
+	return Middleware{
+		ToAPDU: func(next ToAPDUFunc) ToAPDUFunc {
+			return func(ctx context.Context, msg []byte) (proto.Message, error) {
+				start := time.Now()
+				to, err := next(ctx, msg)
+				var apdu *charger.APDU
+				if trData, ok := TRDataFromCtx(ctx); ok {
+					apdu = trData.APDU
+				}
+				log(ctx, "ToAPDU", apdu, start, err)
+				return to, err
+			}
+		},
+		FromAPDU: func(next FromAPDUFunc) FromAPDUFunc {
+			return func(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+				start := time.Now()
+				to, err := next(ctx, apdu)
+				log(ctx, "FromAPDU", apdu, start, err)
+				return to, err
+			}
+		},
+	}
+}
+
+// ErrUnauthorized 桩注册之前上传了不允许的请求
+var ErrUnauthorized = errors.New("桩未注册")
+
+// Authorized 桩注册之前(包括还没有ChargeStation时)只允许上传allowed中的请求, allowed为空时允许启动通知以及注册请求,
+// 请求的消息id在翻译之后才能确定, 被拒绝的报文不会发送到平台
+func Authorized(allowed ...charger.MessageID) Middleware {
+	if len(allowed) == 0 {
+		allowed = []charger.MessageID{charger.MessageID_ID_BootNotificationReq, charger.MessageID_ID_DeviceRegistrationReq}
+	}
+	return Middleware{
+		ToAPDU: func(next ToAPDUFunc) ToAPDUFunc {
+			return func(ctx context.Context, msg []byte) (proto.Message, error) {
+				to, err := next(ctx, msg)
+				if err != nil || to == nil {
+					return to, err
+				}
+				client, ok := ClientFromCtx(ctx)
+				if !ok {
+					return to, err
+				}
+				// 还没有上传启动通知(登录)的桩没有ChargeStation, 与未注册的桩相同处理
+				if chargeStation := client.ChargeStation(); chargeStation != nil && chargeStation.Registered() {
+					return to, err
+				}
+				trData, ok := TRDataFromCtx(ctx)
+				if !ok || trData.APDU == nil || !trData.APDU.IsRequest() {
+					return to, err
+				}
+				for _, id := range allowed {
+					if trData.APDU.MessageId == id {
+						return to, err
+					}
+				}
+				return nil, fmt.Errorf("%w, messageId:%s", ErrUnauthorized, trData.APDU.MessageId)
+			}
+		},
+	}
+}
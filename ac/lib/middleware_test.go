@@ -0,0 +1,86 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/Kotodian/protocol/interfaces"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// translator 上传的报文第一个字节为消息id, 为0时panic
+type translator struct{}
+
+func (translator) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	if msg[0] == 0 {
+		panic("bad frame")
+	}
+	trData, _ := TRDataFromCtx(ctx)
+	trData.APDU = &charger.APDU{MessageId: charger.MessageID(msg[0])}
+	return &charger.MessageError{}, nil
+}
+
+func (translator) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	return apdu.MessageId.String(), nil
+}
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return Middleware{FromAPDU: func(next FromAPDUFunc) FromAPDUFunc {
+			return func(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+				calls = append(calls, name)
+				return next(ctx, apdu)
+			}
+		}}
+	}
+	hub := &Hub{}
+	hub.Use(middleware("a"), Middleware{})
+	hub.SetTR(translator{})
+	hub.Use(middleware("b"))
+	to, err := hub.FromAPDU(context.Background(), &charger.APDU{MessageId: charger.MessageID_ID_HeartbeatReq})
+	assert.Nil(t, err)
+	assert.Equal(t, charger.MessageID_ID_HeartbeatReq.String(), to)
+	assert.Equal(t, []string{"a", "b"}, calls)
+}
+
+func TestRecovery(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	hub := &Hub{}
+	hub.SetTR(translator{})
+	hub.Use(Logging(nil), Recovery())
+	ctx := WithTRData(NewMessageContext(context.Background(), NewTestClient(), zap.New(core)), &TRData{})
+	_, err := hub.ToAPDU(ctx, []byte{0})
+	assert.True(t, errors.Is(err, ErrTranslatePanic))
+	// Recovery在内层, 日志带有堆栈, 外层的Logging记录失败的翻译
+	assert.Len(t, logs.All(), 2)
+	assert.Contains(t, logs.All()[0].ContextMap()["stack"], "TestRecovery")
+	assert.Equal(t, "ToAPDU", logs.All()[1].Message)
+}
+
+func TestAuthorized(t *testing.T) {
+	tr := Chain(translator{}, Authorized())
+	client := NewTestClient()
+	client.SetChargeStation(interfaces.NewDefaultChargeStation("test", false, 1))
+	toAPDU := func(id charger.MessageID) error {
+		ctx := WithTRData(WithClient(context.Background(), client), &TRData{})
+		_, err := tr.ToAPDU(ctx, []byte{byte(id)})
+		return err
+	}
+	assert.Nil(t, toAPDU(charger.MessageID_ID_BootNotificationReq))
+	assert.True(t, errors.Is(toAPDU(charger.MessageID_ID_HeartbeatReq), ErrUnauthorized))
+
+	client.ChargeStation().Register()
+	assert.Nil(t, toAPDU(charger.MessageID_ID_HeartbeatReq))
+
+	// 还没有上传启动通知的桩
+	client.SetChargeStation(nil)
+	assert.Nil(t, client.ChargeStation())
+	assert.Nil(t, toAPDU(charger.MessageID_ID_BootNotificationReq))
+	assert.True(t, errors.Is(toAPDU(charger.MessageID_ID_HeartbeatReq), ErrUnauthorized))
+}
@@ -0,0 +1,55 @@
+// Package padding 分组加密的PKCS#5/PKCS#7填充, 去除填充时严格检查, 错误的报文返回错误而不是panic
+package padding
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// ErrInvalidPadding 数据长度不是块长度的整数倍或者填充内容错误
+var ErrInvalidPadding = errors.New("padding: invalid padding")
+
+// PKCS5BlockSize PKCS#5只用于8字节的块, 例如DES
+const PKCS5BlockSize = 8
+
+// PKCS7Pad 填充到blockSize的整数倍, 总是填充1到blockSize个字节, blockSize必须在1到255之间
+func PKCS7Pad(data []byte, blockSize int) []byte {
+	if blockSize < 1 || blockSize > 255 {
+		panic(fmt.Sprintf("padding: invalid block size %d", blockSize))
+	}
+	padding := blockSize - len(data)%blockSize
+	// 不修改data底层数组中len之后的内容
+	return append(data[:len(data):len(data)], bytes.Repeat([]byte{byte(padding)}, padding)...)
+}
+
+// PKCS7Unpad 去除填充, 返回的数据与data共享底层数组
+func PKCS7Unpad(data []byte, blockSize int) ([]byte, error) {
+	if blockSize < 1 || blockSize > 255 {
+		return nil, fmt.Errorf("%w: block size %d", ErrInvalidPadding, blockSize)
+	}
+	length := len(data)
+	if length == 0 || length%blockSize != 0 {
+		return nil, fmt.Errorf("%w: length %d", ErrInvalidPadding, length)
+	}
+	padding := int(data[length-1])
+	if padding == 0 || padding > blockSize {
+		return nil, fmt.Errorf("%w: padding %d", ErrInvalidPadding, padding)
+	}
+	for _, b := range data[length-padding:] {
+		if int(b) != padding {
+			return nil, ErrInvalidPadding
+		}
+	}
+	return data[:length-padding], nil
+}
+
+// PKCS5Pad 块长度为8的PKCS7Pad
+func PKCS5Pad(data []byte) []byte {
+	return PKCS7Pad(data, PKCS5BlockSize)
+}
+
+// PKCS5Unpad 块长度为8的PKCS7Unpad
+func PKCS5Unpad(data []byte) ([]byte, error) {
+	return PKCS7Unpad(data, PKCS5BlockSize)
+}
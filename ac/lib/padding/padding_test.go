@@ -0,0 +1,65 @@
+package padding
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPKCS7(t *testing.T) {
+	assert.Equal(t, []byte{1, 2, 3, 5, 5, 5, 5, 5}, PKCS5Pad([]byte{1, 2, 3}))
+	// 长度已经是整数倍时填充一个完整的块
+	assert.Equal(t, append([]byte("0123456789ABCDEF"), bytes.Repeat([]byte{16}, 16)...), PKCS7Pad([]byte("0123456789ABCDEF"), 16))
+
+	data, err := PKCS5Unpad([]byte{1, 2, 3, 5, 5, 5, 5, 5})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, data)
+
+	for _, invalid := range [][]byte{
+		nil,
+		{1, 2, 3},
+		{1, 2, 3, 4, 5, 6, 7, 0},
+		{1, 2, 3, 4, 5, 6, 7, 9},
+		{1, 2, 3, 4, 5, 3, 4, 3},
+	} {
+		_, err = PKCS5Unpad(invalid)
+		assert.True(t, errors.Is(err, ErrInvalidPadding), invalid)
+	}
+
+	// 不修改原数组中len之后的内容
+	buf := make([]byte, 3, 16)
+	PKCS7Pad(buf, 16)
+	assert.Equal(t, make([]byte, 16), buf[:16])
+}
+
+func FuzzPKCS7Unpad(f *testing.F) {
+	f.Add([]byte{}, 8)
+	f.Add([]byte{1, 2, 3, 5, 5, 5, 5, 5}, 8)
+	f.Add(bytes.Repeat([]byte{16}, 16), 16)
+	f.Fuzz(func(t *testing.T, data []byte, blockSize int) {
+		unpadded, err := PKCS7Unpad(data, blockSize)
+		if err != nil {
+			return
+		}
+		// 合法的填充去除后再填充得到原来的数据
+		if !bytes.Equal(PKCS7Pad(unpadded, blockSize), data) {
+			t.Fatalf("pad(unpad(%x)) != %x", data, data)
+		}
+	})
+}
+
+func FuzzPKCS7Pad(f *testing.F) {
+	f.Add([]byte{}, 8)
+	f.Add([]byte("0123456789ABCDEF"), 16)
+	f.Fuzz(func(t *testing.T, data []byte, blockSize int) {
+		if blockSize < 1 || blockSize > 255 {
+			return
+		}
+		unpadded, err := PKCS7Unpad(PKCS7Pad(data, blockSize), blockSize)
+		if err != nil || !bytes.Equal(unpadded, data) {
+			t.Fatalf("unpad(pad(%x)) = %x, %v", data, unpadded, err)
+		}
+	})
+}
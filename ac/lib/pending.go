@@ -0,0 +1,91 @@
+package lib
+
+import (
+	"sync"
+	"time"
+)
+
+// PendingRequests 平台下发给桩、尚未收到回复的请求, 以APDU的SequenceId为key
+type PendingRequests struct {
+	mu       sync.Mutex
+	requests map[uint64]*pendingRequest
+}
+
+type pendingRequest struct {
+	timer     *time.Timer
+	onTimeout func()
+}
+
+func NewPendingRequests() *PendingRequests {
+	return &PendingRequests{requests: make(map[uint64]*pendingRequest)}
+}
+
+// Add 记录一个请求, timeout后仍未收到回复时调用onTimeout
+// 相同的sequenceID会覆盖之前的请求
+func (p *PendingRequests) Add(sequenceID uint64, timeout time.Duration, onTimeout func()) {
+	req := &pendingRequest{onTimeout: onTimeout}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old, ok := p.requests[sequenceID]; ok {
+		old.timer.Stop()
+	}
+	req.timer = time.AfterFunc(timeout, func() {
+		if p.remove(sequenceID, req) {
+			onTimeout()
+		}
+	})
+	p.requests[sequenceID] = req
+}
+
+// Resolve 收到回复, 返回该请求是否还在等待
+func (p *PendingRequests) Resolve(sequenceID uint64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	req, ok := p.requests[sequenceID]
+	if !ok {
+		return false
+	}
+	req.timer.Stop()
+	delete(p.requests, sequenceID)
+	return true
+}
+
+// Len 未回复的请求数量
+func (p *PendingRequests) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requests)
+}
+
+// Expire 连接断开时让所有未回复的请求立即超时
+// onTimeout在新的goroutine中调用, 不阻塞关闭连接
+func (p *PendingRequests) Expire() {
+	p.mu.Lock()
+	requests := p.requests
+	p.requests = make(map[uint64]*pendingRequest)
+	p.mu.Unlock()
+	var expired []func()
+	for _, req := range requests {
+		if req.timer.Stop() {
+			expired = append(expired, req.onTimeout)
+		}
+	}
+	if len(expired) == 0 {
+		return
+	}
+	go func() {
+		for _, onTimeout := range expired {
+			onTimeout()
+		}
+	}()
+}
+
+func (p *PendingRequests) remove(sequenceID uint64, req *pendingRequest) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.requests[sequenceID] != req {
+		return false
+	}
+	delete(p.requests, sequenceID)
+	return true
+}
@@ -0,0 +1,42 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingRequests(t *testing.T) {
+	p := NewPendingRequests()
+	timeout := make(chan uint64, 3)
+	for i := uint64(1); i <= 3; i++ {
+		i := i
+		p.Add(i, 20*time.Millisecond, func() {
+			timeout <- i
+		})
+	}
+	assert.Equal(t, 3, p.Len())
+	assert.True(t, p.Resolve(1))
+	assert.False(t, p.Resolve(1))
+	assert.Equal(t, 2, p.Len())
+
+	got := []uint64{<-timeout, <-timeout}
+	assert.ElementsMatch(t, []uint64{2, 3}, got)
+	assert.Equal(t, 0, p.Len())
+
+	p.Add(4, time.Hour, func() {
+		timeout <- 4
+	})
+	p.Expire()
+	assert.Equal(t, uint64(4), <-timeout)
+	assert.Equal(t, 0, p.Len())
+
+	// 回调阻塞时Expire也要立即返回
+	blocked := make(chan uint64)
+	p.Add(5, time.Hour, func() {
+		blocked <- 5
+	})
+	p.Expire()
+	assert.Equal(t, uint64(5), <-blocked)
+}
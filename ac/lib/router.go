@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+)
+
+// ErrNotImplemented 没有处理该消息的函数, 回复平台的MessageError为EC_NotSupported
+var ErrNotImplemented = errors.New("not implemented")
+
+// ErrorCode 回复平台MessageError时err对应的错误码
+func ErrorCode(err error) charger.ErrorCode {
+	if errors.Is(err, ErrNotImplemented) {
+		return charger.ErrorCode_EC_NotSupported
+	}
+	return charger.ErrorCode_EC_GenericError
+}
+
+// FromAPDUHandler 处理平台下发的消息, payload为APDU.Payload解码后的消息
+type FromAPDUHandler func(ctx context.Context, apdu *charger.APDU, payload proto.Message) (interface{}, error)
+
+type route struct {
+	payload proto.Message
+	handler FromAPDUHandler
+}
+
+// Router 按消息id以及桩上传报文的类型选择处理函数, 实现了ITranslate, 可以直接作为Hub的TR
+type Router struct {
+	// FrameType 桩上传报文的类型, 用于选择ToAPDU的处理函数
+	FrameType func(msg []byte) (string, error)
+	// NotFound 没有注册的消息id调用, 默认返回ErrNotImplemented
+	NotFound FromAPDUFunc
+	// NotFoundFrame 没有注册的报文类型调用, 默认返回ErrNotImplemented
+	NotFoundFrame ToAPDUFunc
+
+	routes map[charger.MessageID]route
+	frames map[string]ToAPDUFunc
+}
+
+var _ ITranslate = (*Router)(nil)
+
+// NewRouter frameType为空时只能处理平台下发的消息
+func NewRouter(frameType func(msg []byte) (string, error)) *Router {
+	return &Router{
+		FrameType: frameType,
+		routes:    make(map[charger.MessageID]route),
+		frames:    make(map[string]ToAPDUFunc),
+	}
+}
+
+// Handle 注册消息id的处理函数, payload为APDU.Payload对应的消息类型, 每次处理时复制一个新的消息解码,
+// payload为空时不解码
+func (r *Router) Handle(id charger.MessageID, payload proto.Message, handler FromAPDUHandler) {
+	r.routes[id] = route{payload: payload, handler: handler}
+}
+
+// HandleFrame 注册桩上传报文类型的处理函数
+func (r *Router) HandleFrame(frameType string, handler ToAPDUFunc) {
+	r.frames[frameType] = handler
+}
+
+func (r *Router) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	if r.FrameType == nil {
+		return r.notFoundFrame(ctx, msg)
+	}
+	frameType, err := r.FrameType(msg)
+	if err != nil {
+		return nil, err
+	}
+	handler, ok := r.frames[frameType]
+	if !ok {
+		return r.notFoundFrame(ctx, msg)
+	}
+	return handler(ctx, msg)
+}
+
+func (r *Router) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	rt, ok := r.routes[apdu.MessageId]
+	if !ok {
+		if r.NotFound != nil {
+			return r.NotFound(ctx, apdu)
+		}
+		return nil, fmt.Errorf("%w, messageId:%s", ErrNotImplemented, apdu.MessageId)
+	}
+	var payload proto.Message
+	if rt.payload != nil {
+		payload = proto.Clone(rt.payload)
+		if err := proto.Unmarshal(apdu.Payload, payload); err != nil {
+			return nil, fmt.Errorf("decode %s payload error, err:%s", apdu.MessageId, err.Error())
+		}
+	}
+	return rt.handler(ctx, apdu, payload)
+}
+
+func (r *Router) notFoundFrame(ctx context.Context, msg []byte) (proto.Message, error) {
+	if r.NotFoundFrame != nil {
+		return r.NotFoundFrame(ctx, msg)
+	}
+	return nil, ErrNotImplemented
+}
@@ -0,0 +1,49 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	router := NewRouter(func(msg []byte) (string, error) {
+		if len(msg) == 0 {
+			return "", errors.New("empty frame")
+		}
+		return string(msg[:1]), nil
+	})
+	router.HandleFrame("h", func(ctx context.Context, msg []byte) (proto.Message, error) {
+		return &charger.MessageError{Description: string(msg)}, nil
+	})
+	router.Handle(charger.MessageID_ID_RemoteControlReq, &charger.MessageError{}, func(ctx context.Context, apdu *charger.APDU, payload proto.Message) (interface{}, error) {
+		return payload.(*charger.MessageError).Description, nil
+	})
+	ctx := context.Background()
+
+	to, err := router.ToAPDU(ctx, []byte("heartbeat"))
+	assert.Nil(t, err)
+	assert.Equal(t, "heartbeat", to.(*charger.MessageError).Description)
+	_, err = router.ToAPDU(ctx, []byte("x"))
+	assert.True(t, errors.Is(err, ErrNotImplemented))
+	_, err = router.ToAPDU(ctx, nil)
+	assert.NotNil(t, err)
+
+	payload, _ := proto.Marshal(&charger.MessageError{Description: "start"})
+	for i := 0; i < 2; i++ {
+		msg, err := router.FromAPDU(ctx, &charger.APDU{MessageId: charger.MessageID_ID_RemoteControlReq, Payload: payload})
+		assert.Nil(t, err)
+		assert.Equal(t, "start", msg)
+	}
+	_, err = router.FromAPDU(ctx, &charger.APDU{MessageId: charger.MessageID_ID_RemoteControlReq, Payload: []byte{0xff}})
+	assert.NotNil(t, err)
+
+	_, err = router.FromAPDU(ctx, &charger.APDU{MessageId: charger.MessageID_ID_HeartbeatReq})
+	assert.True(t, errors.Is(err, ErrNotImplemented))
+	assert.Equal(t, charger.ErrorCode_EC_NotSupported, ErrorCode(err))
+	assert.Equal(t, charger.ErrorCode_EC_GenericError, ErrorCode(errors.New("x")))
+}
@@ -0,0 +1,109 @@
+package lib
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// ClientStats 客户端的统计信息
+type ClientStats struct {
+	SN            string    `json:"sn"`
+	CoreID        uint64    `json:"coreId"`
+	RemoteAddress string    `json:"remoteAddress"`
+	Registered    bool      `json:"registered"`
+	SendQueue     int       `json:"sendQueue"` // 等待发送给桩的报文数量
+	MqttQueue     int       `json:"mqttQueue"` // 等待处理的平台消息数量
+	Pending       int       `json:"pending"`   // 等待桩回复的请求数量
+	LastSeen      time.Time `json:"lastSeen"`  // 最后一次收到桩报文的时间
+	BytesIn       uint64    `json:"bytesIn"`
+	BytesOut      uint64    `json:"bytesOut"`
+	DroppedBytes  uint64    `json:"droppedBytes"` // 解码失败丢弃的字节数
+}
+
+// HubStats 网关的统计信息
+type HubStats struct {
+	Connected       int           `json:"connected"`   // 已经注册的客户端数量
+	Registering     int           `json:"registering"` // 正在注册的客户端数量
+	PubQueue        int           `json:"pubQueue"`    // 等待发送到MQTT的消息数量
+	PublishErrors   uint64        `json:"publishErrors"`
+	TranslateErrors uint64        `json:"translateErrors"`
+	SpoolBytes      int64         `json:"spoolBytes"`   // 缓存在磁盘上等待补发的消息大小
+	Duplicates      uint64        `json:"duplicates"`   // 同一个桩重复连接, 关闭旧连接的次数
+	AuthFailures    uint64        `json:"authFailures"` // 桩认证失败, 拒绝连接的次数
+	Clients         []ClientStats `json:"clients"`
+}
+
+// ClientStatsOf 客户端的统计信息, 没有实现ClientMonitor时只有基本信息
+func ClientStatsOf(client ClientInterface) ClientStats {
+	if monitor, ok := client.(ClientMonitor); ok {
+		return monitor.Stats()
+	}
+	stats := ClientStats{RemoteAddress: client.RemoteAddress()}
+	if chargeStation := client.ChargeStation(); chargeStation != nil {
+		stats.SN = chargeStation.SN()
+		stats.CoreID = chargeStation.CoreID()
+	}
+	return stats
+}
+
+// Traffic 客户端的流量统计, 由tcp以及websocket客户端嵌入
+type Traffic struct {
+	lastSeen int64
+	bytesIn  uint64
+	bytesOut uint64
+}
+
+// Received 收到桩的报文
+func (t *Traffic) Received(n int) {
+	atomic.StoreInt64(&t.lastSeen, time.Now().UnixNano())
+	atomic.AddUint64(&t.bytesIn, uint64(n))
+}
+
+// Sent 发送给桩的报文
+func (t *Traffic) Sent(n int) {
+	atomic.AddUint64(&t.bytesOut, uint64(n))
+}
+
+// Fill 将流量统计填充到ClientStats中
+func (t *Traffic) Fill(stats *ClientStats) {
+	if lastSeen := atomic.LoadInt64(&t.lastSeen); lastSeen > 0 {
+		stats.LastSeen = time.Unix(0, lastSeen)
+	}
+	stats.BytesIn = atomic.LoadUint64(&t.bytesIn)
+	stats.BytesOut = atomic.LoadUint64(&t.bytesOut)
+}
+
+// AddTranslateError 记录一次协议翻译失败
+func (h *Hub) AddTranslateError() {
+	atomic.AddUint64(&h.translateErrors, 1)
+}
+
+// Stats 网关当前的统计信息
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		PubQueue:        len(h.PubMqttMsg),
+		PublishErrors:   atomic.LoadUint64(&h.publishErrors),
+		TranslateErrors: atomic.LoadUint64(&h.translateErrors),
+		Duplicates:      atomic.LoadUint64(&h.duplicates),
+		AuthFailures:    atomic.LoadUint64(&h.authFailures),
+	}
+	if h.Spool != nil {
+		stats.SpoolBytes = h.Spool.Size()
+	}
+	h.Clients.Range(func(key, value interface{}) bool {
+		clientStats := ClientStatsOf(value.(ClientInterface))
+		clientStats.Registered = true
+		stats.Clients = append(stats.Clients, clientStats)
+		stats.Connected++
+		return true
+	})
+	h.RegClients.Range(func(key, value interface{}) bool {
+		if _, ok := h.Clients.Load(key); ok {
+			return true
+		}
+		stats.Clients = append(stats.Clients, ClientStatsOf(value.(ClientInterface)))
+		stats.Registering++
+		return true
+	})
+	return stats
+}
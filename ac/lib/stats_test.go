@@ -0,0 +1,43 @@
+package lib
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubStats(t *testing.T) {
+	hub := &Hub{CommandTimeout: time.Hour}
+	registered := NewTestClient()
+	hub.Clients.Store(uint64(1), registered)
+	hub.RegClients.Store(uint64(1), registered)
+	hub.RegClients.Store(uint64(2), registered)
+	hub.AddTranslateError()
+
+	stats := hub.Stats()
+	assert.Equal(t, 1, stats.Connected)
+	assert.Equal(t, 1, stats.Registering)
+	assert.Len(t, stats.Clients, 2)
+	assert.Equal(t, uint64(1), stats.TranslateErrors)
+
+	// 没有实现ClientMonitor的客户端只有基本信息
+	plain := struct{ ClientInterface }{NewTestClient()}
+	hub.Clients.Store(uint64(3), plain)
+	hub.TrackCommand(plain, "gateway/command/3", &charger.APDU{MessageId: charger.MessageID_ID_RemoteControlReq, SequenceId: 1})
+	stats = hub.Stats()
+	assert.Equal(t, 2, stats.Connected)
+	assert.Contains(t, stats.Clients, ClientStats{SN: "test", RemoteAddress: plain.RemoteAddress(), Registered: true})
+}
+
+func TestTraffic(t *testing.T) {
+	var traffic Traffic
+	traffic.Received(10)
+	traffic.Sent(4)
+	var stats ClientStats
+	traffic.Fill(&stats)
+	assert.Equal(t, uint64(10), stats.BytesIn)
+	assert.Equal(t, uint64(4), stats.BytesOut)
+	assert.False(t, stats.LastSeen.IsZero())
+}
@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	mqttClient "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Transport 网关与平台之间的消息通道, 默认使用MQTT
+type Transport interface {
+	// Publish 发送消息, ctx超时或者取消时返回错误
+	Publish(ctx context.Context, m mqtt.MqttMessage) error
+	// Subscribe 订阅消息, filters为topic与qos, 同一个Transport的handler按顺序调用
+	Subscribe(filters map[string]byte, handler func(m mqtt.MqttMessage)) error
+	// Unsubscribe 取消订阅
+	Unsubscribe(filters ...string) error
+	// IsConnected 当前是否可以发送消息
+	IsConnected() bool
+	// OnDisconnect 连接断开时调用f
+	OnDisconnect(f func(err error))
+}
+
+// MQTTTransport 基于paho的Transport
+type MQTTTransport struct {
+	client *mqtt.MQTTClient
+
+	mu           sync.Mutex
+	onDisconnect []func(err error)
+}
+
+var _ Transport = (*MQTTTransport)(nil)
+
+// NewMQTTTransport 根据options创建paho客户端, 不会连接, 需要调用Connect
+// options中原有的ConnectionLostHandler仍然会被调用
+func NewMQTTTransport(options *mqttClient.ClientOptions) *MQTTTransport {
+	t := &MQTTTransport{}
+	lost := options.OnConnectionLost
+	options.SetConnectionLostHandler(func(c mqttClient.Client, err error) {
+		if lost != nil {
+			lost(c, err)
+		}
+		t.disconnected(err)
+	})
+	t.client = mqtt.NewMQTTClient(options)
+	return t
+}
+
+// WrapMQTTTransport 使用已经创建的客户端, 连接断开时不会调用OnDisconnect注册的函数
+func WrapMQTTTransport(client *mqtt.MQTTClient) *MQTTTransport {
+	return &MQTTTransport{client: client}
+}
+
+// Connect 连接到MQTT服务器
+func (t *MQTTTransport) Connect() error {
+	return t.client.Connect()
+}
+
+// Client paho客户端
+func (t *MQTTTransport) Client() *mqtt.MQTTClient {
+	return t.client
+}
+
+func (t *MQTTTransport) Publish(ctx context.Context, m mqtt.MqttMessage) error {
+	token := t.client.GetMQTT().Publish(m.Topic, m.Qos, m.Retained, m.Payload)
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *MQTTTransport) Subscribe(filters map[string]byte, handler func(m mqtt.MqttMessage)) error {
+	if len(filters) == 0 {
+		return errors.New("no topic to subscribe")
+	}
+	token := t.client.GetMQTT().SubscribeMultiple(filters, func(_ mqttClient.Client, m mqttClient.Message) {
+		handler(mqtt.MqttMessage{
+			Topic:    m.Topic(),
+			Payload:  m.Payload(),
+			Qos:      m.Qos(),
+			Retained: m.Retained(),
+		})
+	})
+	return wait(token)
+}
+
+func (t *MQTTTransport) Unsubscribe(filters ...string) error {
+	return wait(t.client.GetMQTT().Unsubscribe(filters...))
+}
+
+func (t *MQTTTransport) IsConnected() bool {
+	return t.client.GetMQTT().IsConnectionOpen()
+}
+
+func (t *MQTTTransport) OnDisconnect(f func(err error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onDisconnect = append(t.onDisconnect, f)
+}
+
+func (t *MQTTTransport) disconnected(err error) {
+	t.mu.Lock()
+	fs := append([]func(err error){}, t.onDisconnect...)
+	t.mu.Unlock()
+	for _, f := range fs {
+		f(err)
+	}
+}
+
+// wait 超时后不返回错误, 与原来直接使用paho时的行为一致
+func wait(token mqttClient.Token) error {
+	token.WaitTimeout(subscribeTimeout)
+	return token.Error()
+}
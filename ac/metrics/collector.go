@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector 将lib.Hub的统计信息导出为prometheus指标
+type Collector struct {
+	hub *lib.Hub
+	// perClient 是否导出每个桩的指标, 桩较多时会产生大量的时间序列
+	perClient bool
+
+	connected       *prometheus.Desc
+	registering     *prometheus.Desc
+	pubQueue        *prometheus.Desc
+	publishErrors   *prometheus.Desc
+	translateErrors *prometheus.Desc
+	spoolBytes      *prometheus.Desc
+	duplicates      *prometheus.Desc
+	authFailures    *prometheus.Desc
+
+	sendQueue *prometheus.Desc
+	mqttQueue *prometheus.Desc
+	pending   *prometheus.Desc
+	lastSeen  *prometheus.Desc
+	bytesIn   *prometheus.Desc
+	bytesOut  *prometheus.Desc
+	dropped   *prometheus.Desc
+}
+
+// NewCollector 创建指标收集器, 需要调用prometheus.MustRegister注册
+func NewCollector(hub *lib.Hub, perClient bool) *Collector {
+	hubLabels := prometheus.Labels{"protocol": hub.Protocol, "version": hub.ProtocolVersion}
+	clientLabels := []string{"sn"}
+	return &Collector{
+		hub:       hub,
+		perClient: perClient,
+
+		connected:       prometheus.NewDesc("gateway_connected_clients", "Number of registered chargers.", nil, hubLabels),
+		registering:     prometheus.NewDesc("gateway_registering_clients", "Number of chargers waiting for registration.", nil, hubLabels),
+		pubQueue:        prometheus.NewDesc("gateway_publish_queue_length", "Number of messages waiting to be published to MQTT.", nil, hubLabels),
+		publishErrors:   prometheus.NewDesc("gateway_publish_errors_total", "Number of failed MQTT publishes.", nil, hubLabels),
+		translateErrors: prometheus.NewDesc("gateway_translate_errors_total", "Number of failed protocol translations.", nil, hubLabels),
+		spoolBytes:      prometheus.NewDesc("gateway_spool_bytes", "Bytes of messages spooled on disk waiting to be published.", nil, hubLabels),
+		duplicates:      prometheus.NewDesc("gateway_duplicate_connections_total", "Number of stale connections closed because the charger connected again.", nil, hubLabels),
+		authFailures:    prometheus.NewDesc("gateway_auth_failures_total", "Number of connections rejected by the authenticator.", nil, hubLabels),
+
+		sendQueue: prometheus.NewDesc("gateway_client_send_queue_length", "Number of frames waiting to be sent to the charger.", clientLabels, hubLabels),
+		mqttQueue: prometheus.NewDesc("gateway_client_mqtt_queue_length", "Number of platform messages waiting to be handled.", clientLabels, hubLabels),
+		pending:   prometheus.NewDesc("gateway_client_pending_requests", "Number of commands waiting for the charger's reply.", clientLabels, hubLabels),
+		lastSeen:  prometheus.NewDesc("gateway_client_last_seen_timestamp_seconds", "Last time a frame was received from the charger.", clientLabels, hubLabels),
+		bytesIn:   prometheus.NewDesc("gateway_client_received_bytes_total", "Bytes received from the charger.", clientLabels, hubLabels),
+		bytesOut:  prometheus.NewDesc("gateway_client_sent_bytes_total", "Bytes sent to the charger.", clientLabels, hubLabels),
+		dropped:   prometheus.NewDesc("gateway_client_dropped_bytes_total", "Bytes discarded because they could not be decoded.", clientLabels, hubLabels),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connected
+	ch <- c.registering
+	ch <- c.pubQueue
+	ch <- c.publishErrors
+	ch <- c.translateErrors
+	ch <- c.spoolBytes
+	ch <- c.duplicates
+	ch <- c.authFailures
+	if c.perClient {
+		ch <- c.sendQueue
+		ch <- c.mqttQueue
+		ch <- c.pending
+		ch <- c.lastSeen
+		ch <- c.bytesIn
+		ch <- c.bytesOut
+		ch <- c.dropped
+	}
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.hub.Stats()
+	ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue, float64(stats.Connected))
+	ch <- prometheus.MustNewConstMetric(c.registering, prometheus.GaugeValue, float64(stats.Registering))
+	ch <- prometheus.MustNewConstMetric(c.pubQueue, prometheus.GaugeValue, float64(stats.PubQueue))
+	ch <- prometheus.MustNewConstMetric(c.publishErrors, prometheus.CounterValue, float64(stats.PublishErrors))
+	ch <- prometheus.MustNewConstMetric(c.translateErrors, prometheus.CounterValue, float64(stats.TranslateErrors))
+	ch <- prometheus.MustNewConstMetric(c.spoolBytes, prometheus.GaugeValue, float64(stats.SpoolBytes))
+	ch <- prometheus.MustNewConstMetric(c.duplicates, prometheus.CounterValue, float64(stats.Duplicates))
+	ch <- prometheus.MustNewConstMetric(c.authFailures, prometheus.CounterValue, float64(stats.AuthFailures))
+	if !c.perClient {
+		return
+	}
+	// 同一个sn可能存在多个连接, 重复的标签会导致采集失败
+	seen := make(map[string]struct{}, len(stats.Clients))
+	for _, client := range stats.Clients {
+		if _, ok := seen[client.SN]; ok {
+			continue
+		}
+		seen[client.SN] = struct{}{}
+		ch <- prometheus.MustNewConstMetric(c.sendQueue, prometheus.GaugeValue, float64(client.SendQueue), client.SN)
+		ch <- prometheus.MustNewConstMetric(c.mqttQueue, prometheus.GaugeValue, float64(client.MqttQueue), client.SN)
+		ch <- prometheus.MustNewConstMetric(c.pending, prometheus.GaugeValue, float64(client.Pending), client.SN)
+		if !client.LastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastSeen, prometheus.GaugeValue, float64(client.LastSeen.Unix()), client.SN)
+		}
+		ch <- prometheus.MustNewConstMetric(c.bytesIn, prometheus.CounterValue, float64(client.BytesIn), client.SN)
+		ch <- prometheus.MustNewConstMetric(c.bytesOut, prometheus.CounterValue, float64(client.BytesOut), client.SN)
+		ch <- prometheus.MustNewConstMetric(c.dropped, prometheus.CounterValue, float64(client.DroppedBytes), client.SN)
+	}
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollector(t *testing.T) {
+	hub := &lib.Hub{Protocol: "ocpp", ProtocolVersion: "1.6"}
+	client := lib.NewTestClient()
+	hub.Clients.Store(uint64(1), client)
+	hub.RegClients.Store(uint64(2), client)
+	hub.AddTranslateError()
+	hub.AddAuthFailure()
+
+	expected := `
+# HELP gateway_connected_clients Number of registered chargers.
+# TYPE gateway_connected_clients gauge
+gateway_connected_clients{protocol="ocpp",version="1.6"} 1
+# HELP gateway_registering_clients Number of chargers waiting for registration.
+# TYPE gateway_registering_clients gauge
+gateway_registering_clients{protocol="ocpp",version="1.6"} 1
+# HELP gateway_translate_errors_total Number of failed protocol translations.
+# TYPE gateway_translate_errors_total counter
+gateway_translate_errors_total{protocol="ocpp",version="1.6"} 1
+# HELP gateway_auth_failures_total Number of connections rejected by the authenticator.
+# TYPE gateway_auth_failures_total counter
+gateway_auth_failures_total{protocol="ocpp",version="1.6"} 1
+`
+	err := testutil.CollectAndCompare(NewCollector(hub, false), strings.NewReader(expected),
+		"gateway_connected_clients", "gateway_registering_clients", "gateway_translate_errors_total", "gateway_auth_failures_total")
+	assert.Nil(t, err)
+	assert.Equal(t, 8, testutil.CollectAndCount(NewCollector(hub, false)))
+
+	// 同一个sn的两个连接只导出一次
+	expected = `
+# HELP gateway_client_pending_requests Number of commands waiting for the charger's reply.
+# TYPE gateway_client_pending_requests gauge
+gateway_client_pending_requests{protocol="ocpp",sn="test",version="1.6"} 0
+`
+	collector := NewCollector(hub, true)
+	err = testutil.CollectAndCompare(collector, strings.NewReader(expected), "gateway_client_pending_requests")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "gateway_client_dropped_bytes_total"))
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Latency 协议翻译耗时的直方图, 按方向和消息id区分
+type Latency struct {
+	histogram *prometheus.HistogramVec
+}
+
+// NewLatency 创建协议翻译耗时的直方图, 需要调用prometheus.MustRegister注册, 通过hub.Use(latency.Middleware())开启
+func NewLatency(hub *lib.Hub, buckets []float64) *Latency {
+	if len(buckets) == 0 {
+		buckets = prometheus.DefBuckets
+	}
+	return &Latency{
+		histogram: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "gateway_translate_duration_seconds",
+			Help:        "Time spent translating messages between the charger protocol and APDU.",
+			ConstLabels: prometheus.Labels{"protocol": hub.Protocol, "version": hub.ProtocolVersion},
+			Buckets:     buckets,
+		}, []string{"direction", "message"}),
+	}
+}
+
+func (l *Latency) Describe(ch chan<- *prometheus.Desc) {
+	l.histogram.Describe(ch)
+}
+
+func (l *Latency) Collect(ch chan<- prometheus.Metric) {
+	l.histogram.Collect(ch)
+}
+
+// Middleware 记录ToAPDU以及FromAPDU的耗时, 翻译失败时消息id可能未知
+func (l *Latency) Middleware() lib.Middleware {
+	return lib.Middleware{
+		ToAPDU: func(next lib.ToAPDUFunc) lib.ToAPDUFunc {
+			return func(ctx context.Context, msg []byte) (proto.Message, error) {
+				start := time.Now()
+				to, err := next(ctx, msg)
+				var apdu *charger.APDU
+				if trData, ok := lib.TRDataFromCtx(ctx); ok {
+					apdu = trData.APDU
+				}
+				l.observe("to_apdu", apdu, start)
+				return to, err
+			}
+		},
+		FromAPDU: func(next lib.FromAPDUFunc) lib.FromAPDUFunc {
+			return func(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+				start := time.Now()
+				to, err := next(ctx, apdu)
+				l.observe("from_apdu", apdu, start)
+				return to, err
+			}
+		},
+	}
+}
+
+func (l *Latency) observe(direction string, apdu *charger.APDU, start time.Time) {
+	message := "unknown"
+	if apdu != nil {
+		message = apdu.MessageId.String()
+	}
+	l.histogram.WithLabelValues(direction, message).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,386 @@
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Kotodian/gokit/datasource/mqtt"
+)
+
+const (
+	segmentExt = ".seg"
+	offsetFile = "offset"
+	// headerSize 每条记录的头部: 4字节长度 + 4字节crc32
+	headerSize = 8
+)
+
+var (
+	ErrEmpty  = errors.New("spool is empty")
+	ErrFull   = errors.New("spool is full")
+	ErrClosed = errors.New("spool is closed")
+)
+
+// Spool 基于本地文件的消息队列, MQTT不可用时缓存发送到平台的消息
+//
+// 消息按顺序追加到分段文件中, 读取位置保存在offset文件里, 重启后从上次确认的位置继续读取.
+// Append可以并发调用, Peek和Ack只能由一个消费者调用.
+type Spool struct {
+	dir          string
+	maxBytes     int64
+	segmentBytes int64
+
+	mu sync.Mutex
+	// size 所有分段文件的大小
+	size int64
+	// 正在写入的分段
+	writer    *os.File
+	writeID   uint64
+	writeSize int64
+	// 正在读取的分段
+	reader     *os.File
+	readID     uint64
+	readOffset int64
+	readSize   int64
+	// next Peek之后下一条记录的位置
+	next   int64
+	closed bool
+}
+
+// Open 打开或者创建目录下的队列, maxBytes为磁盘占用的上限, segmentBytes为单个分段文件的大小
+func Open(dir string, maxBytes, segmentBytes int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	s := &Spool{dir: dir, maxBytes: maxBytes, segmentBytes: segmentBytes}
+	ids, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+	s.readID, s.readOffset, err = s.loadOffset()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		if id < s.readID {
+			_ = os.Remove(s.path(id))
+			continue
+		}
+		info, err := os.Stat(s.path(id))
+		if err != nil {
+			return nil, err
+		}
+		s.size += info.Size()
+		s.writeID = id
+	}
+	if s.writeID == 0 || s.writeID < s.readID {
+		if s.readID == 0 {
+			s.readID = 1
+		}
+		s.writeID, s.readOffset = s.readID, 0
+	} else if s.readID < ids[0] {
+		s.readID, s.readOffset = ids[0], 0
+	}
+	if err = s.openWriter(); err != nil {
+		return nil, err
+	}
+	if err = s.recover(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Append 追加一条消息, 超过磁盘占用上限时返回ErrFull
+func (s *Spool) Append(m mqtt.MqttMessage) error {
+	record := encode(m)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.maxBytes > 0 && s.size+int64(len(record)) > s.maxBytes {
+		return ErrFull
+	}
+	if s.writeSize > 0 && s.writeSize+int64(len(record)) > s.segmentBytes {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.writer.Write(record)
+	s.writeSize += int64(n)
+	s.size += int64(n)
+	return err
+}
+
+// Peek 读取最早的一条消息, 调用Ack后才会移除
+func (s *Spool) Peek() (mqtt.MqttMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return mqtt.MqttMessage{}, ErrClosed
+	}
+	for {
+		if err := s.openReader(); err != nil {
+			return mqtt.MqttMessage{}, err
+		}
+		if s.readID == s.writeID {
+			s.readSize = s.writeSize
+		}
+		if s.readOffset >= s.readSize {
+			if s.readID == s.writeID {
+				return mqtt.MqttMessage{}, ErrEmpty
+			}
+			if err := s.nextSegment(); err != nil {
+				return mqtt.MqttMessage{}, err
+			}
+			continue
+		}
+		m, n, err := readRecord(s.reader, s.readOffset, s.readSize)
+		if err != nil {
+			// 记录损坏时丢弃该分段剩余的数据
+			if s.readID == s.writeID {
+				if err = s.rotate(); err != nil {
+					return mqtt.MqttMessage{}, err
+				}
+			}
+			if err = s.nextSegment(); err != nil {
+				return mqtt.MqttMessage{}, err
+			}
+			continue
+		}
+		s.next = s.readOffset + n
+		return m, nil
+	}
+}
+
+// Ack 确认Peek读取的消息已经处理完
+func (s *Spool) Ack() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if s.next <= s.readOffset {
+		return nil
+	}
+	s.readOffset = s.next
+	if s.readID == s.writeID && s.readOffset >= s.writeSize {
+		// 全部读取完了, 换一个新的分段以便释放磁盘空间
+		if err := s.rotate(); err != nil {
+			return err
+		}
+		return s.nextSegment()
+	}
+	return s.saveOffset()
+}
+
+// Empty 是否没有未确认的消息
+func (s *Spool) Empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.readID == s.writeID && s.readOffset >= s.writeSize
+}
+
+// Size 磁盘占用的字节数
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	if s.reader != nil {
+		_ = s.reader.Close()
+	}
+	_ = s.saveOffset()
+	return s.writer.Close()
+}
+
+func (s *Spool) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, segmentExt))
+}
+
+func (s *Spool) segments() ([]uint64, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func (s *Spool) loadOffset() (id uint64, offset int64, err error) {
+	b, err := os.ReadFile(filepath.Join(s.dir, offsetFile))
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	if _, err = fmt.Sscanf(string(b), "%d %d", &id, &offset); err != nil {
+		return 0, 0, nil
+	}
+	return id, offset, nil
+}
+
+// saveOffset 先写临时文件再重命名, 避免写一半时进程退出
+func (s *Spool) saveOffset() error {
+	tmp := filepath.Join(s.dir, offsetFile+".tmp")
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", s.readID, s.readOffset)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, offsetFile))
+}
+
+func (s *Spool) openWriter() error {
+	f, err := os.OpenFile(s.path(s.writeID), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	s.writer, s.writeSize = f, info.Size()
+	return nil
+}
+
+// recover 截掉最后一个分段中写了一半的记录
+func (s *Spool) recover() error {
+	var offset int64
+	for offset < s.writeSize {
+		_, n, err := readRecord(s.writer, offset, s.writeSize)
+		if err != nil {
+			break
+		}
+		offset += n
+	}
+	if offset == s.writeSize {
+		return nil
+	}
+	if err := s.writer.Truncate(offset); err != nil {
+		return err
+	}
+	s.size -= s.writeSize - offset
+	s.writeSize = offset
+	return nil
+}
+
+func (s *Spool) rotate() error {
+	if s.readID == s.writeID {
+		// 该分段不再写入, 读取时以当前的大小为准
+		s.readSize = s.writeSize
+	}
+	if err := s.writer.Close(); err != nil {
+		return err
+	}
+	s.writeID++
+	return s.openWriter()
+}
+
+// nextSegment 删除已经读完的分段, 开始读取下一个分段
+func (s *Spool) nextSegment() error {
+	if s.reader != nil {
+		_ = s.reader.Close()
+		s.reader = nil
+	}
+	if info, err := os.Stat(s.path(s.readID)); err == nil {
+		s.size -= info.Size()
+	}
+	_ = os.Remove(s.path(s.readID))
+	s.readID++
+	s.readOffset, s.readSize, s.next = 0, 0, 0
+	return s.saveOffset()
+}
+
+func (s *Spool) openReader() error {
+	if s.reader != nil {
+		return nil
+	}
+	f, err := os.Open(s.path(s.readID))
+	if err != nil {
+		return err
+	}
+	if s.readID != s.writeID {
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return err
+		}
+		s.readSize = info.Size()
+	}
+	s.reader = f
+	return nil
+}
+
+// encode 记录格式: 长度(4) crc32(4) qos(1) retained(1) topic长度(2) topic payload
+func encode(m mqtt.MqttMessage) []byte {
+	bodySize := 4 + len(m.Topic) + len(m.Payload)
+	record := make([]byte, headerSize+bodySize)
+	body := record[headerSize:]
+	body[0] = m.Qos
+	if m.Retained {
+		body[1] = 1
+	}
+	binary.BigEndian.PutUint16(body[2:4], uint16(len(m.Topic)))
+	copy(body[4:], m.Topic)
+	copy(body[4+len(m.Topic):], m.Payload)
+	binary.BigEndian.PutUint32(record[0:4], uint32(bodySize))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(body))
+	return record
+}
+
+// readRecord 读取offset处的记录, 长度超过分段剩余大小size的记录按照损坏处理
+func readRecord(r io.ReaderAt, offset, size int64) (m mqtt.MqttMessage, n int64, err error) {
+	header := make([]byte, headerSize)
+	if _, err = r.ReadAt(header, offset); err != nil {
+		return m, 0, err
+	}
+	bodySize := binary.BigEndian.Uint32(header[0:4])
+	if bodySize < 4 || int64(bodySize) > size-offset-headerSize {
+		return m, 0, errors.New("invalid record")
+	}
+	body := make([]byte, bodySize)
+	if _, err = r.ReadAt(body, offset+headerSize); err != nil {
+		return m, 0, err
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:8]) {
+		return m, 0, errors.New("checksum mismatch")
+	}
+	topicSize := int(binary.BigEndian.Uint16(body[2:4]))
+	if 4+topicSize > len(body) {
+		return m, 0, errors.New("invalid record")
+	}
+	m.Qos = body[0]
+	m.Retained = body[1] == 1
+	m.Topic = string(body[4 : 4+topicSize])
+	m.Payload = body[4+topicSize:]
+	return m, int64(headerSize) + int64(bodySize), nil
+}
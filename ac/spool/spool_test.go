@@ -0,0 +1,94 @@
+package spool
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	"github.com/stretchr/testify/assert"
+)
+
+func message(i int) mqtt.MqttMessage {
+	return mqtt.MqttMessage{
+		Topic:   fmt.Sprintf("coregw/host/command/%d", i),
+		Qos:     2,
+		Payload: []byte{byte(i)},
+	}
+}
+
+func TestSpoolOrder(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 64)
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, s.Append(message(i)))
+	}
+	for i := 0; i < 4; i++ {
+		m, err := s.Peek()
+		assert.Nil(t, err)
+		assert.Equal(t, message(i), m)
+		assert.Nil(t, s.Ack())
+	}
+	// 未确认的消息在重启后仍然存在
+	_, err = s.Peek()
+	assert.Nil(t, err)
+	assert.Nil(t, s.Close())
+
+	s, err = Open(dir, 0, 64)
+	assert.Nil(t, err)
+	for i := 4; i < 10; i++ {
+		m, err := s.Peek()
+		assert.Nil(t, err)
+		assert.Equal(t, message(i), m)
+		assert.Nil(t, s.Ack())
+	}
+	_, err = s.Peek()
+	assert.Equal(t, ErrEmpty, err)
+	assert.True(t, s.Empty())
+	assert.Equal(t, int64(0), s.Size())
+	assert.Nil(t, s.Close())
+}
+
+func TestSpoolFull(t *testing.T) {
+	s, err := Open(t.TempDir(), 100, 1024)
+	assert.Nil(t, err)
+	defer s.Close()
+	var appended int
+	for i := 0; i < 10; i++ {
+		if err = s.Append(message(i)); err != nil {
+			break
+		}
+		appended++
+	}
+	assert.Equal(t, ErrFull, err)
+	assert.True(t, appended > 0)
+	assert.LessOrEqual(t, s.Size(), int64(100))
+}
+
+func TestSpoolCorruptLength(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, 0, 1024)
+	assert.Nil(t, err)
+	assert.Nil(t, s.Append(message(1)))
+	size := s.Size()
+	assert.Nil(t, s.Close())
+
+	// 损坏的长度不能按原样分配内存, 重启时作为写了一半的记录截掉
+	f, err := os.OpenFile(s.path(1), os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0xff, 0xff, 0xff, 0xf0, 0, 0, 0, 0, 1, 2, 3, 4})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = Open(dir, 0, 1024)
+	assert.Nil(t, err)
+	defer s.Close()
+	assert.Equal(t, size, s.Size())
+	m, err := s.Peek()
+	assert.Nil(t, err)
+	assert.Equal(t, message(1), m)
+	assert.Nil(t, s.Ack())
+	_, err = s.Peek()
+	assert.Equal(t, ErrEmpty, err)
+}
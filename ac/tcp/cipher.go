@@ -0,0 +1,111 @@
+package tcp
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/bytedance/gopkg/lang/mcache"
+)
+
+// ErrEncryptType 帧头中的加密方式与桩协商的加密方式不一致
+var ErrEncryptType = errors.New("unexpected encrypt type")
+
+// Cipher 按帧头中的加密方式解密桩上传的帧, 按Client的加密方式加密下发的帧
+//
+// 帧的格式为: 帧头(包含加密方式) + 数据域 + 帧尾(校验码, 结束符), 只有数据域加密
+type Cipher struct {
+	// Registry 按类型查找加密方式, 为空时使用lib.DefaultEncrypts
+	Registry *lib.EncryptRegistry
+	// TypeOffset 加密方式在帧中的位置
+	TypeOffset int
+	// DataOffset 数据域的起始位置
+	DataOffset int
+	// Trailer 帧尾的长度, 不参与加密
+	Trailer int
+	// Fix 加密或者解密后数据域的长度会改变, 用于回填长度域以及重新计算校验码, 为空时不处理;
+	// 可以直接修改frame后返回, 也可以返回新分配的帧, 不需要释放frame
+	Fix func(frame []byte) ([]byte, error)
+}
+
+func (c *Cipher) registry() *lib.EncryptRegistry {
+	if c.Registry == nil {
+		return lib.DefaultEncrypts
+	}
+	return c.Registry
+}
+
+func (c *Cipher) split(frame []byte) (header, data, trailer []byte, err error) {
+	if c.TypeOffset >= c.DataOffset || len(frame) < c.DataOffset+c.Trailer {
+		return nil, nil, nil, fmt.Errorf("%w: length %d", ErrInvalidFrame, len(frame))
+	}
+	return frame[:c.DataOffset], frame[c.DataOffset : len(frame)-c.Trailer], frame[len(frame)-c.Trailer:], nil
+}
+
+// Decrypt 解密数据域, 返回的帧通过mcache分配, 由调用方使用mcache.Free释放, 不释放frame;
+// Fix返回新分配的帧时复制到mcache分配的帧中, 调用方不需要区分
+//
+// encrypt为桩协商的加密方式, 不为空时帧头中的加密方式必须与其相同, 防止降级为不加密或者较弱的加密方式;
+// 为空时按帧头中的加密方式从Registry中查找, 已经设置了key时不接受不加密的帧
+func (c *Cipher) Decrypt(frame []byte, encrypt lib.Encrypt, key []byte) ([]byte, error) {
+	header, data, trailer, err := c.split(frame)
+	if err != nil {
+		return nil, err
+	}
+	t := header[c.TypeOffset]
+	switch {
+	case encrypt != nil:
+		if t != encrypt.Type() {
+			return nil, fmt.Errorf("%w: 0x%02X, expected 0x%02X", ErrEncryptType, t, encrypt.Type())
+		}
+		data, err = encrypt.Decode(data, key)
+	case len(key) > 0 && t == lib.EncryptTypeNone:
+		return nil, fmt.Errorf("%w: plaintext after key exchange", ErrEncryptType)
+	default:
+		data, err = c.registry().Decode(t, data, key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	decrypted := mcache.Malloc(len(header) + len(data) + len(trailer))
+	copy(decrypted[copy(decrypted, header):], data)
+	copy(decrypted[len(header)+len(data):], trailer)
+	fixed, err := c.fix(decrypted)
+	if err != nil {
+		mcache.Free(decrypted)
+		return nil, err
+	}
+	// Fix重新分配了帧
+	if cap(fixed) == 0 || &fixed[:1][0] != &decrypted[0] {
+		frame = mcache.Malloc(len(fixed))
+		copy(frame, fixed)
+		mcache.Free(decrypted)
+		return frame, nil
+	}
+	return fixed, nil
+}
+
+// Encrypt 使用encrypt加密数据域并写入加密方式, encrypt为空时不加密
+func (c *Cipher) Encrypt(frame []byte, encrypt lib.Encrypt, key []byte) ([]byte, error) {
+	if encrypt == nil {
+		return frame, nil
+	}
+	header, data, trailer, err := c.split(frame)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = encrypt.Encode(data, key); err != nil {
+		return nil, err
+	}
+	encrypted := make([]byte, 0, len(header)+len(data)+len(trailer))
+	encrypted = append(append(append(encrypted, header...), data...), trailer...)
+	encrypted[c.TypeOffset] = encrypt.Type()
+	return c.fix(encrypted)
+}
+
+func (c *Cipher) fix(frame []byte) ([]byte, error) {
+	if c.Fix == nil {
+		return frame, nil
+	}
+	return c.Fix(frame)
+}
@@ -0,0 +1,150 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// 帧格式: 0x68 + 数据域长度 + 加密方式 + 数据域 + 校验和
+func newTestCipher() *Cipher {
+	return &Cipher{
+		TypeOffset: 2,
+		DataOffset: 3,
+		Trailer:    1,
+		Fix: func(frame []byte) ([]byte, error) {
+			frame[1] = byte(len(frame) - 4)
+			var sum byte
+			for _, b := range frame[:len(frame)-1] {
+				sum += b
+			}
+			frame[len(frame)-1] = sum
+			return frame, nil
+		},
+	}
+}
+
+func TestCipher(t *testing.T) {
+	cipher := newTestCipher()
+	key := []byte("1234567812345678")
+	plain := []byte{0x68, 0x03, lib.EncryptTypeNone, 0x01, 0x02, 0x03, 0x00}
+
+	// 交换密钥之前不加密
+	frame, err := cipher.Encrypt(append([]byte(nil), plain...), nil, key)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, frame)
+	frame, err = cipher.Decrypt(plain, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x68, 0x03, lib.EncryptTypeNone, 0x01, 0x02, 0x03, 0x71}, frame)
+
+	for _, encrypt := range []lib.Encrypt{lib.NewAESEncrypt(lib.CBC), lib.NewAESEncrypt(lib.GCM)} {
+		frame, err = cipher.Encrypt(append([]byte(nil), plain...), encrypt, key)
+		assert.Nil(t, err)
+		assert.Equal(t, encrypt.Type(), frame[2])
+		assert.Equal(t, len(frame)-4, int(frame[1]))
+
+		decrypted, err := cipher.Decrypt(frame, nil, key)
+		assert.Nil(t, err)
+		assert.Equal(t, []byte{0x68, 0x03, encrypt.Type(), 0x01, 0x02, 0x03}, decrypted[:6])
+	}
+
+	// 交换密钥之后不接受不加密或者其他加密方式的帧
+	_, err = cipher.Decrypt(plain, nil, key)
+	assert.True(t, errors.Is(err, ErrEncryptType))
+	gcm := lib.NewAESEncrypt(lib.GCM)
+	_, err = cipher.Decrypt(plain, gcm, key)
+	assert.True(t, errors.Is(err, ErrEncryptType))
+	frame, _ = cipher.Encrypt(append([]byte(nil), plain...), lib.NewAESEncrypt(lib.ECB), key)
+	_, err = cipher.Decrypt(frame, gcm, key)
+	assert.True(t, errors.Is(err, ErrEncryptType))
+	frame, _ = cipher.Encrypt(append([]byte(nil), plain...), gcm, key)
+	_, err = cipher.Decrypt(frame, gcm, key)
+	assert.Nil(t, err)
+
+	_, err = cipher.Decrypt([]byte{0x68, 0x00, 0xFF, 0x00}, nil, key)
+	assert.True(t, errors.Is(err, lib.ErrEncryptNotFound))
+	_, err = cipher.Decrypt([]byte{0x68, 0x00}, nil, key)
+	assert.True(t, errors.Is(err, ErrInvalidFrame))
+
+	// Fix返回新分配的帧或者错误
+	reallocate := &Cipher{TypeOffset: 2, DataOffset: 3, Trailer: 1, Fix: func(frame []byte) ([]byte, error) {
+		return append([]byte{0x00}, frame...), nil
+	}}
+	frame, err = reallocate.Decrypt(plain, nil, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, append([]byte{0x00}, plain...), frame)
+	failed := &Cipher{TypeOffset: 2, DataOffset: 3, Trailer: 1, Fix: func(frame []byte) ([]byte, error) {
+		return nil, ErrInvalidFrame
+	}}
+	_, err = failed.Decrypt(plain, nil, nil)
+	assert.Equal(t, ErrInvalidFrame, err)
+}
+
+// frameTranslator 把桩上传的帧发送到frames
+type frameTranslator struct {
+	frames chan []byte
+}
+
+func (f frameTranslator) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	f.frames <- append([]byte(nil), msg...)
+	return nil, nil
+}
+
+func (f frameTranslator) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	return nil, nil
+}
+
+func TestClientCipher(t *testing.T) {
+	frames := make(chan []byte, 4)
+	hub := &lib.Hub{}
+	hub.SetTR(frameTranslator{frames: frames})
+	conn, device := net.Pipe()
+	defer device.Close()
+	cipher := newTestCipher()
+	client := NewClient(hub, conn, 60, "test", &rabbitmq.Logger{Logger: zap.NewNop()}, NewHeaderCodec(1, 4, 0x68), nil)
+	client.(*Client).SetCipher(cipher)
+	client.SetClientOfflineFunc(func(err error) {})
+	go client.ReadPump()
+	defer client.Close(nil)
+
+	receive := func() []byte {
+		select {
+		case frame := <-frames:
+			return frame
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+	plain := []byte{0x68, 0x03, lib.EncryptTypeNone, 0x01, 0x02, 0x03, 0x71}
+	// 帧头之前的垃圾数据计入DroppedBytes
+	_, err := device.Write([]byte{0x00, 0x01})
+	assert.Nil(t, err)
+	_, err = device.Write(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, plain, receive())
+	assert.Equal(t, uint64(2), client.(*Client).Stats().DroppedBytes)
+
+	// 交换密钥之后不加密的帧被丢弃
+	key := []byte("1234567812345678")
+	gcm := lib.NewAESEncrypt(lib.GCM)
+	client.SetEncryptKey(string(key))
+	client.SetEncrypt(gcm)
+	_, err = device.Write(plain)
+	assert.Nil(t, err)
+	assert.Nil(t, receive())
+
+	encrypted, err := cipher.Encrypt(append([]byte(nil), plain...), gcm, key)
+	assert.Nil(t, err)
+	_, err = device.Write(encrypted)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x68, 0x03, lib.EncryptTypeAESGCM, 0x01, 0x02, 0x03}, receive()[:6])
+}
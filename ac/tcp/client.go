@@ -5,13 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"net"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Kotodian/gokit/ac/capture"
 	"github.com/Kotodian/gokit/ac/lib"
 	"github.com/Kotodian/gokit/datasource"
 	"github.com/Kotodian/gokit/datasource/mqtt"
@@ -57,6 +58,8 @@ type Client struct {
 	coregw string
 	// 加密key
 	encryptKey []byte
+	// encryptMu 保护encryptKey和encrypt, 密钥交换在处理报文的goroutine中设置
+	encryptMu sync.RWMutex
 	// 平台端id
 	id string
 	// 连接
@@ -73,29 +76,43 @@ type Client struct {
 	data          sync.Map
 	// 加密解密方式
 	encrypt lib.Encrypt
-	// 长度索引
-	headerLengthIndex int
-	headerLength      int
-	headerStart       byte
-}
-
-func NewClient(hub *lib.Hub, conn net.Conn, keepalive int64, remoteAddress string, log *rabbitmq.Logger, headerLengthIndex, headerLength int, headerStart byte) lib.ClientInterface {
+	// 按帧加密解密, 为nil时不处理
+	cipher *Cipher
+	// 帧解码
+	decoder FrameDecoder
+	// 帧编码, 为nil时原样发送
+	encoder FrameEncoder
+	// 解码失败丢弃的字节数
+	droppedBytes int64
+	// 等待桩回复的请求
+	pending *lib.PendingRequests
+	// 流量统计
+	traffic lib.Traffic
+}
+
+var _ lib.ClientMonitor = (*Client)(nil)
+
+// NewClient 创建tcp客户端, encoder为nil时发送的报文不做处理
+// 兼容原有的帧格式可以使用 NewHeaderCodec(headerLengthIndex, headerLength, headerStart)
+func NewClient(hub *lib.Hub, conn net.Conn, keepalive int64, remoteAddress string, log *rabbitmq.Logger, decoder FrameDecoder, encoder FrameEncoder) lib.ClientInterface {
 	client := &Client{
-		log:               log,
-		hub:               hub,
-		conn:              conn,
-		remoteAddress:     remoteAddress,
-		send:              make(chan []byte, 5),
-		mqttMsgCh:         make(chan mqtt.MqttMessage, 5),
-		mqttRegCh:         make(chan mqtt.MqttMessage, 5),
-		close:             make(chan struct{}),
-		keepalive:         keepalive,
-		orderInterval:     30,
-		isClose:           false,
-		messageNumber:     0,
-		headerLengthIndex: headerLengthIndex,
-		headerLength:      headerLength,
-		headerStart:       headerStart,
+		log:           log,
+		hub:           hub,
+		conn:          conn,
+		remoteAddress: remoteAddress,
+		send:          make(chan []byte, 5),
+		mqttMsgCh:     make(chan mqtt.MqttMessage, 5),
+		mqttRegCh:     make(chan mqtt.MqttMessage, 5),
+		close:         make(chan struct{}),
+		keepalive:     keepalive,
+		orderInterval: 30,
+		isClose:       false,
+		messageNumber: 0,
+		decoder:       decoder,
+		encoder:       encoder,
+		pending:       lib.NewPendingRequests(),
+		// 默认的离线通知不做任何处理
+		clientOfflineNotifyFunc: func(err error) {},
 	}
 	return client
 }
@@ -106,6 +123,18 @@ func (c *Client) Send(msg []byte) (err error) {
 			err = e.(error)
 		}
 	}()
+	c.hub.Capture(c.sn(), capture.KindFrameOut, "", msg)
+	if c.cipher != nil {
+		encrypt, key := c.encryption()
+		if msg, err = c.cipher.Encrypt(msg, encrypt, key); err != nil {
+			return err
+		}
+	}
+	if c.encoder != nil {
+		if msg, err = c.encoder.Encode(msg); err != nil {
+			return err
+		}
+	}
 	c.send <- msg
 	return
 }
@@ -118,12 +147,12 @@ func (c *Client) Close(err error) error {
 		c.log.Error(err.Error())
 		_ = c.conn.Close()
 		if c.chargeStation != nil {
-			c.hub.Clients.Delete(c.chargeStation.CoreID())
-			c.hub.RegClients.Delete(c.chargeStation.CoreID())
+			c.hub.RemoveClient(c)
+			c.hub.ReleaseOwnership(c)
 			c.log.Sugar().Info(c.chargeStation.SN(), "关闭连接")
 		}
-		c.conn = nil
 		c.data = sync.Map{}
+		c.pending.Expire()
 		close(c.send)
 		close(c.close)
 
@@ -134,7 +163,7 @@ func (c *Client) Close(err error) error {
 }
 
 func (c *Client) SubRegMQTT() {
-	c.hub.RegClients.Store(c.chargeStation.CoreID(), c)
+	c.hub.AddRegClient(c)
 	for {
 		select {
 		case <-c.close:
@@ -158,13 +187,13 @@ func (c *Client) SubRegMQTT() {
 				}
 
 				// 将客户端信息以及消息放入到上下文中
-				ctx := context.WithValue(context.TODO(), "client", c)
-				ctx = context.WithValue(ctx, "trData", trData)
+				ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
+				ctx = lib.WithTRData(ctx, trData)
 
 				var msg interface{}
 
 				// 处理并翻译成桩端需要的结果
-				if msg, err = c.hub.TR.FromAPDU(ctx, &apdu); err != nil {
+				if msg, err = c.hub.FromAPDU(ctx, &apdu); err != nil {
 					err = fmt.Errorf("FromAPDU register error, err: %s topic: %s", err.Error(), topic)
 					return
 				} else if msg == nil {
@@ -175,7 +204,7 @@ func (c *Client) SubRegMQTT() {
 					return
 				}
 				if apdu.IsRequest() {
-					c.sendCommand(ctx, msg)
+					_ = c.sendCommand(ctx, msg)
 				} else {
 					c.Reply(ctx, msg)
 				}
@@ -192,12 +221,12 @@ func (c *Client) Reply(ctx context.Context, payload interface{}) {
 	_ = c.Send(resp)
 }
 
-func (c *Client) sendCommand(ctx context.Context, payload interface{}) {
+func (c *Client) sendCommand(ctx context.Context, payload interface{}) error {
 	command, err := c.hub.CommandFn(ctx, payload)
 	if err != nil {
-		return
+		return err
 	}
-	_ = c.Send(command)
+	return c.Send(command)
 }
 
 func (c *Client) ReplyError(ctx context.Context, err error, desc ...string) {
@@ -208,7 +237,8 @@ func (c *Client) ReplyError(ctx context.Context, err error, desc ...string) {
 }
 
 func (c *Client) SubMQTT() {
-	c.hub.Clients.Store(c.chargeStation.CoreID(), c)
+	c.hub.AddClient(c)
+	c.hub.ClaimOwnership(c)
 	// wp := workpool.New(1, 5).Start()
 	// defer wp.Stop()
 	for {
@@ -230,8 +260,8 @@ func (c *Client) SubMQTT() {
 					APDU:  &apdu,
 					Topic: topic,
 				}
-				ctx := context.WithValue(context.TODO(), "client", c)
-				ctx = context.WithValue(ctx, "trData", trData)
+				ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
+				ctx = lib.WithTRData(ctx, trData)
 				var msg interface{}
 
 				var err error
@@ -243,7 +273,7 @@ func (c *Client) SubMQTT() {
 							if apdu.MessageId != charger.MessageID_ID_MessageError {
 								apdu.MessageId = charger.MessageID_ID_MessageError
 								apdu.Payload, _ = proto.Marshal(&charger.MessageError{
-									Error:       charger.ErrorCode_EC_GenericError,
+									Error:       lib.ErrorCode(err),
 									Description: err.Error(),
 								})
 							}
@@ -254,11 +284,13 @@ func (c *Client) SubMQTT() {
 								Retained: false,
 								Payload:  apduEncoded,
 							}
+							c.hub.Capture(c.sn(), capture.KindMQTTOut, pubMqttMsg.Topic, pubMqttMsg.Payload)
 							c.hub.PubMqttMsg <- pubMqttMsg
 						}
 					}
 				}()
-				if msg, err = c.hub.TR.FromAPDU(ctx, &apdu); err != nil {
+				if msg, err = c.hub.FromAPDU(ctx, &apdu); err != nil {
+					c.hub.AddTranslateError()
 					return
 				} else if msg == nil {
 					return
@@ -267,7 +299,10 @@ func (c *Client) SubMQTT() {
 					return
 				}
 				if apdu.IsRequest() {
-					c.sendCommand(ctx, msg)
+					c.hub.TrackCommand(c, topic, &apdu)
+					if e := c.sendCommand(ctx, msg); e != nil {
+						c.hub.UntrackCommand(c, &apdu)
+					}
 				} else {
 					c.Reply(ctx, msg)
 				}
@@ -344,92 +379,104 @@ func (c *Client) ReadPump() {
 		return
 	}
 	reader := bufio.NewReader(c.conn)
+	dispatcher := lib.NewDispatcher(c.hub.MaxInflight)
+	defer dispatcher.Stop(lib.DispatchStopTimeout)
 
 	for {
-		if c.conn == nil {
-			return
-		}
-		var peek []byte
-		peek, err = reader.Peek(c.headerLengthIndex + 1)
-		if err != nil {
-			return
-		}
-		if peek[0] != c.headerStart {
-			c.log.Error("first byte is invalid" + fmt.Sprintf("%X", reader.Buffered()))
-			_, _ = reader.Discard(reader.Buffered())
+		var msg []byte
+		msg, err = c.decoder.Decode(reader)
+		var discardErr *DiscardError
+		if errors.As(err, &discardErr) {
+			c.traffic.Received(discardErr.Discarded)
+			total := atomic.AddInt64(&c.droppedBytes, int64(discardErr.Discarded))
+			c.log.Error(err.Error(), zap.String("sn", c.sn()), zap.Int64("dropped", total))
+			err = nil
 			continue
 		}
-		dataLength := int(peek[c.headerLengthIndex])
-		length := dataLength + c.headerLength
-		// if reader.Buffered() < length {
-		// 	continue
-		// }
-		msg := mcache.Malloc(length)
-		// msg := make([]byte, dataLength+c.headerLength)
-		_, err = io.ReadFull(reader, msg)
 		if err != nil {
 			return
 		}
+		c.traffic.Received(len(msg))
 		err = c.conn.SetReadDeadline(time.Now().Add(readWait))
 		if err != nil {
 			return
 		}
-		ctx := context.WithValue(context.TODO(), "client", c)
-		go func(ctx context.Context, msg []byte) {
-			trData := &lib.TRData{}
-			ctx = context.WithValue(ctx, "trData", trData)
-			var err error
-
-			var payload proto.Message
-			defer func() {
-				if r := recover(); r != nil {
-					c.log.Sugar().Errorf("%v", r)
-				}
-				mcache.Free(msg)
-			}()
-
-			if payload, err = c.hub.TR.ToAPDU(ctx, msg); err != nil {
-				return
+		if c.cipher != nil {
+			encrypt, key := c.encryption()
+			decrypted, e := c.cipher.Decrypt(msg, encrypt, key)
+			mcache.Free(msg)
+			if e != nil {
+				// 解密失败只丢弃这一帧
+				c.log.Error("decrypt frame error, err:"+e.Error(), zap.String("sn", c.sn()))
+				continue
 			}
+			msg = decrypted
+		}
+		// 抓包记录解密后的报文, 与回放时ToAPDU的输入一致
+		c.hub.Capture(c.sn(), capture.KindFrameIn, "", msg)
+		ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
+		dispatcher.Dispatch(func() {
+			c.handle(ctx, msg)
+		})
+	}
+}
 
-			if payload == nil {
-				return
-			}
+// handle 将桩上传的报文翻译后发送到平台
+func (c *Client) handle(ctx context.Context, msg []byte) {
+	trData := &lib.TRData{}
+	ctx = lib.WithTRData(ctx, trData)
+	var err error
 
-			if trData.Ignore {
-				return
-			}
+	var payload proto.Message
+	defer func() {
+		if r := recover(); r != nil {
+			c.log.Error(fmt.Sprintf("%v", r), zap.String("sn", c.sn()), zap.Stack("stack"))
+		}
+		mcache.Free(msg)
+	}()
 
-			if trData.APDU.Payload, err = proto.Marshal(payload); err != nil {
-				err = fmt.Errorf("encode cmd req payload error, err:%s", err.Error())
-				return
-			}
-			var toCoreMSG []byte
-			if toCoreMSG, err = proto.Marshal(trData.APDU); err != nil {
-				err = fmt.Errorf("encode cmd req apdu error, err:%s", err.Error())
-				return
-			}
+	if payload, err = c.hub.ToAPDU(ctx, msg); err != nil {
+		c.hub.AddTranslateError()
+		return
+	}
+	c.hub.ResolveCommand(c, trData.APDU)
 
-			var sendTopic string
-			var sendQos byte
-			if trData.IsTelemetry {
-				sendTopic = "coregw/" + c.hub.Hostname + "/telemetry/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			} else if !trData.Sync {
-				sendTopic = "coregw/" + c.hub.Hostname + "/command/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			} else {
-				sendTopic = c.coregw + "/sync/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			}
-			sendQos = 2
-			if c.chargeStation != nil {
-				c.hub.PubMqttMsg <- mqtt.MqttMessage{
-					Topic:    sendTopic,
-					Qos:      sendQos,
-					Retained: false,
-					Payload:  toCoreMSG,
-				}
-			}
-		}(ctx, msg)
+	if payload == nil {
+		return
+	}
 
+	if trData.Ignore {
+		return
+	}
+
+	if trData.APDU.Payload, err = proto.Marshal(payload); err != nil {
+		err = fmt.Errorf("encode cmd req payload error, err:%s", err.Error())
+		return
+	}
+	var toCoreMSG []byte
+	if toCoreMSG, err = proto.Marshal(trData.APDU); err != nil {
+		err = fmt.Errorf("encode cmd req apdu error, err:%s", err.Error())
+		return
+	}
+
+	var sendTopic string
+	var sendQos byte
+	if trData.IsTelemetry {
+		sendTopic = "coregw/" + c.hub.Hostname + "/telemetry/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	} else if !trData.Sync {
+		sendTopic = "coregw/" + c.hub.Hostname + "/command/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	} else {
+		sendTopic = c.coregw + "/sync/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	}
+	sendQos = 2
+	if c.chargeStation != nil {
+		c.hub.Capture(c.sn(), capture.KindMQTTOut, sendTopic, toCoreMSG)
+		c.hub.PubMqttMsg <- mqtt.MqttMessage{
+			Topic:    sendTopic,
+			Qos:      sendQos,
+			Retained: false,
+			Payload:  toCoreMSG,
+		}
 	}
 }
 
@@ -446,9 +493,6 @@ func (c *Client) WritePump() {
 		case <-c.close:
 			return
 		case message, ok := <-c.send:
-			if c.conn == nil {
-				return
-			}
 			_ = c.conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
 				err = errors.New("send on closed channel")
@@ -462,17 +506,34 @@ func (c *Client) WritePump() {
 			if err != nil {
 				return
 			}
+			c.traffic.Sent(len(message))
 		}
 	}
 }
+
+// DroppedBytes 解码失败丢弃的字节总数
+func (c *Client) DroppedBytes() int64 {
+	return atomic.LoadInt64(&c.droppedBytes)
+}
+
+// sn 注册前桩实体为空, 使用客户端地址
+func (c *Client) sn() string {
+	if c.chargeStation != nil {
+		return c.chargeStation.SN()
+	}
+	return c.remoteAddress
+}
+
 func (c *Client) Hub() *lib.Hub {
 	return c.hub
 }
 func (c *Client) PublishReg(m mqtt.MqttMessage) {
+	c.hub.Capture(c.sn(), capture.KindMQTTIn, m.Topic, m.Payload)
 	c.mqttRegCh <- m
 }
 
 func (c *Client) Publish(m mqtt.MqttMessage) {
+	c.hub.Capture(c.sn(), capture.KindMQTTIn, m.Topic, m.Payload)
 	c.mqttMsgCh <- m
 }
 
@@ -513,22 +574,43 @@ func (c *Client) SetCoregw(coregw string) {
 }
 
 func (c *Client) SetEncryptKey(encryptKey string) {
+	c.encryptMu.Lock()
+	defer c.encryptMu.Unlock()
 	c.encryptKey = []byte(encryptKey)
 }
 
 func (c *Client) Encrypt() lib.Encrypt {
+	c.encryptMu.RLock()
+	defer c.encryptMu.RUnlock()
 	return c.encrypt
 }
 
 func (c *Client) SetEncrypt(encrypt lib.Encrypt) {
+	c.encryptMu.Lock()
+	defer c.encryptMu.Unlock()
 	c.encrypt = encrypt
 }
 
+// encryption 同时返回加密方式和密钥, 避免在密钥交换的过程中取到不一致的值
+func (c *Client) encryption() (lib.Encrypt, []byte) {
+	c.encryptMu.RLock()
+	defer c.encryptMu.RUnlock()
+	return c.encrypt, c.encryptKey
+}
+
+// SetCipher 桩上传的帧按帧头中的加密方式解密, 下发的帧使用Encrypt()加密,
+// 设置了Encrypt()之后只接受使用该加密方式的帧
+func (c *Client) SetCipher(cipher *Cipher) {
+	c.cipher = cipher
+}
+
 func (c *Client) IsClose() bool {
 	return c.isClose
 }
 
 func (c *Client) EncryptKey() []byte {
+	c.encryptMu.RLock()
+	defer c.encryptMu.RUnlock()
 	return c.encryptKey
 }
 
@@ -539,6 +621,7 @@ func (c *Client) PingHandler(msg string) error {
 	if err != nil {
 		c.log.Error(err.Error(), zap.String("sn", c.chargeStation.SN()))
 	}
+	c.hub.RefreshOwnership(c)
 	return nil
 }
 
@@ -608,3 +691,23 @@ func (c *Client) BaseURL() string {
 func (c *Client) SetBaseURL(baseURL string) {
 	c.baseURL = baseURL
 }
+
+func (c *Client) Pending() *lib.PendingRequests {
+	return c.pending
+}
+
+func (c *Client) Stats() lib.ClientStats {
+	stats := lib.ClientStats{
+		SN:            c.sn(),
+		RemoteAddress: c.remoteAddress,
+		SendQueue:     len(c.send),
+		MqttQueue:     len(c.mqttMsgCh),
+		Pending:       c.pending.Len(),
+		DroppedBytes:  uint64(c.DroppedBytes()),
+	}
+	if c.chargeStation != nil {
+		stats.CoreID = c.chargeStation.CoreID()
+	}
+	c.traffic.Fill(&stats)
+	return stats
+}
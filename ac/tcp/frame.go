@@ -0,0 +1,324 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/bytedance/gopkg/lang/mcache"
+)
+
+var (
+	// ErrInvalidFrame 帧格式错误
+	ErrInvalidFrame = errors.New("invalid frame")
+	// ErrFrameTooLarge 帧长度超过限制
+	ErrFrameTooLarge = errors.New("frame too large")
+)
+
+// DiscardError 无法解析时丢弃的字节数
+type DiscardError struct {
+	// Discarded 丢弃的字节数
+	Discarded int
+	// Reason 丢弃的原因
+	Reason error
+}
+
+func (e *DiscardError) Error() string {
+	return fmt.Sprintf("discard %d bytes: %s", e.Discarded, e.Reason.Error())
+}
+
+func (e *DiscardError) Unwrap() error {
+	return e.Reason
+}
+
+// NewCheckSumVerify 校验帧尾两个字节的crc16(lib.CheckSum), start为参与校验的起始位置
+func NewCheckSumVerify(start int) func(frame []byte) bool {
+	return func(frame []byte) bool {
+		if len(frame) < start+2 {
+			return false
+		}
+		return bytes.Equal(lib.CheckSum(frame[start:len(frame)-2]), frame[len(frame)-2:])
+	}
+}
+
+// NewCheckVerify 校验帧尾的校验码, start为参与校验的起始位置, trailer为校验码之后的字节数(例如结束符)
+func NewCheckVerify(check lib.Check, start, trailer int) func(frame []byte) bool {
+	size := len(check.Generate(nil))
+	return func(frame []byte) bool {
+		end := len(frame) - trailer - size
+		if end < start {
+			return false
+		}
+		return lib.VerifyCheck(check, frame[start:end], frame[end:end+size])
+	}
+}
+
+// FrameDecoder 从字节流中切分出一个完整的帧
+type FrameDecoder interface {
+	// Decode 读取一帧, 返回的帧通过mcache分配, 由Client处理完后释放
+	Decode(reader *bufio.Reader) ([]byte, error)
+}
+
+// FrameEncoder 发送前对帧进行封装
+type FrameEncoder interface {
+	Encode(msg []byte) ([]byte, error)
+}
+
+// FrameCodec 同时实现了解码和编码
+type FrameCodec interface {
+	FrameDecoder
+	FrameEncoder
+}
+
+// LengthFieldCodec 通过帧头中的长度域切分帧
+//
+// 帧总长度 = 长度域的值 + Adjustment, 例如:
+//   - 长度域只表示数据域长度: Adjustment = 帧头长度(+ 校验码长度)
+//   - 长度域表示整个帧的长度: Adjustment = 0
+type LengthFieldCodec struct {
+	// Start 起始字节, 为空时不校验
+	Start []byte
+	// LengthOffset 长度域在帧中的偏移
+	LengthOffset int
+	// LengthSize 长度域的字节数, 支持1、2、4
+	LengthSize int
+	// ByteOrder 长度域的字节序, 默认小端
+	ByteOrder binary.ByteOrder
+	// Adjustment 长度域的值与帧总长度的差值
+	Adjustment int
+	// MaxFrameLength 最大帧长度, 为0时不限制
+	MaxFrameLength int
+	// MinFrameLength 最小帧长度, 为0时至少是帧头的长度
+	MinFrameLength int
+	// Resync 遇到错误的帧时逐字节跳到下一个起始符, 而不是丢弃整个缓冲区
+	// 开启后帧长度不能超过reader的缓冲区大小
+	Resync bool
+	// Verify 开启Resync时对完整的帧进行校验, 为nil时不校验
+	Verify func(frame []byte) bool
+}
+
+// NewHeaderCodec 一个字节的起始符以及一个字节的数据域长度
+// headerLengthIndex 长度域的位置, headerLength 帧头(以及帧尾)的长度, headerStart 起始符
+func NewHeaderCodec(headerLengthIndex, headerLength int, headerStart byte) *LengthFieldCodec {
+	return &LengthFieldCodec{
+		Start:        []byte{headerStart},
+		LengthOffset: headerLengthIndex,
+		LengthSize:   1,
+		Adjustment:   headerLength,
+	}
+}
+
+func (l *LengthFieldCodec) byteOrder() binary.ByteOrder {
+	if l.ByteOrder == nil {
+		return binary.LittleEndian
+	}
+	return l.ByteOrder
+}
+
+func (l *LengthFieldCodec) headerSize() int {
+	size := l.LengthOffset + l.LengthSize
+	if len(l.Start) > size {
+		size = len(l.Start)
+	}
+	return size
+}
+
+func (l *LengthFieldCodec) readLength(header []byte) (int, error) {
+	field := header[l.LengthOffset : l.LengthOffset+l.LengthSize]
+	switch l.LengthSize {
+	case 1:
+		return int(field[0]), nil
+	case 2:
+		return int(l.byteOrder().Uint16(field)), nil
+	case 4:
+		return int(l.byteOrder().Uint32(field)), nil
+	}
+	return 0, fmt.Errorf("unsupported length size %d", l.LengthSize)
+}
+
+func (l *LengthFieldCodec) writeLength(header []byte, n int) error {
+	field := header[l.LengthOffset : l.LengthOffset+l.LengthSize]
+	switch l.LengthSize {
+	case 1:
+		if n > 0xFF {
+			return ErrFrameTooLarge
+		}
+		field[0] = byte(n)
+	case 2:
+		if n > 0xFFFF {
+			return ErrFrameTooLarge
+		}
+		l.byteOrder().PutUint16(field, uint16(n))
+	case 4:
+		l.byteOrder().PutUint32(field, uint32(n))
+	default:
+		return fmt.Errorf("unsupported length size %d", l.LengthSize)
+	}
+	return nil
+}
+
+// frameLength 根据帧头计算帧总长度
+func (l *LengthFieldCodec) frameLength(header []byte) (int, error) {
+	if !bytes.HasPrefix(header, l.Start) {
+		return 0, fmt.Errorf("%w: start %X", ErrInvalidFrame, header[:len(l.Start)])
+	}
+	dataLength, err := l.readLength(header)
+	if err != nil {
+		return 0, err
+	}
+	length := dataLength + l.Adjustment
+	if length < l.headerSize() || length < l.MinFrameLength {
+		return 0, fmt.Errorf("%w: length %d", ErrInvalidFrame, length)
+	}
+	if l.MaxFrameLength > 0 && length > l.MaxFrameLength {
+		return 0, fmt.Errorf("%w: length %d", ErrFrameTooLarge, length)
+	}
+	return length, nil
+}
+
+func (l *LengthFieldCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	header, err := reader.Peek(l.headerSize())
+	if err != nil {
+		return nil, err
+	}
+	length, err := l.frameLength(header)
+	if err == nil && l.Resync {
+		err = l.verify(reader, length)
+		if err != nil && !errors.Is(err, ErrInvalidFrame) && !errors.Is(err, ErrFrameTooLarge) {
+			return nil, err
+		}
+	}
+	if err != nil {
+		if l.Resync {
+			return nil, &DiscardError{Discarded: l.skip(reader), Reason: err}
+		}
+		// 无法确定帧边界, 丢弃已缓冲的数据
+		discarded, _ := reader.Discard(reader.Buffered())
+		return nil, &DiscardError{Discarded: discarded, Reason: err}
+	}
+	msg := mcache.Malloc(length)
+	if _, err = io.ReadFull(reader, msg); err != nil {
+		mcache.Free(msg)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// verify 在不消费数据的情况下校验完整的帧
+func (l *LengthFieldCodec) verify(reader *bufio.Reader, length int) error {
+	if length > reader.Size() {
+		return fmt.Errorf("%w: length %d", ErrFrameTooLarge, length)
+	}
+	frame, err := reader.Peek(length)
+	if err != nil {
+		return err
+	}
+	if l.Verify != nil && !l.Verify(frame) {
+		return fmt.Errorf("%w: verify failed", ErrInvalidFrame)
+	}
+	return nil
+}
+
+// skip 跳过当前字节以及之后不是起始符的字节
+func (l *LengthFieldCodec) skip(reader *bufio.Reader) int {
+	discarded, _ := reader.Discard(1)
+	if len(l.Start) == 0 {
+		return discarded
+	}
+	buffered, _ := reader.Peek(reader.Buffered())
+	n := bytes.IndexByte(buffered, l.Start[0])
+	if n < 0 {
+		n = len(buffered)
+	}
+	n, _ = reader.Discard(n)
+	return discarded + n
+}
+
+// Encode 校验起始符并回填长度域
+func (l *LengthFieldCodec) Encode(msg []byte) ([]byte, error) {
+	if len(msg) < l.headerSize() || !bytes.HasPrefix(msg, l.Start) {
+		return nil, ErrInvalidFrame
+	}
+	if err := l.writeLength(msg, len(msg)-l.Adjustment); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// DelimiterCodec 以分隔符结尾的帧
+type DelimiterCodec struct {
+	// Delimiter 分隔符
+	Delimiter []byte
+	// Strip 解码时是否去掉分隔符
+	Strip bool
+	// MaxFrameLength 最大帧长度, 为0时不限制
+	MaxFrameLength int
+}
+
+func (d *DelimiterCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	if len(d.Delimiter) == 0 {
+		return nil, errors.New("empty delimiter")
+	}
+	last := d.Delimiter[len(d.Delimiter)-1]
+	var frame []byte
+	for {
+		line, err := reader.ReadSlice(last)
+		frame = append(frame, line...)
+		if d.MaxFrameLength > 0 && len(frame) > d.MaxFrameLength {
+			return nil, &DiscardError{Discarded: len(frame), Reason: ErrFrameTooLarge}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if bytes.HasSuffix(frame, d.Delimiter) {
+			break
+		}
+	}
+	if d.Strip {
+		frame = frame[:len(frame)-len(d.Delimiter)]
+	}
+	msg := mcache.Malloc(len(frame))
+	copy(msg, frame)
+	return msg, nil
+}
+
+// Encode 在帧尾追加分隔符
+func (d *DelimiterCodec) Encode(msg []byte) ([]byte, error) {
+	if bytes.HasSuffix(msg, d.Delimiter) {
+		return msg, nil
+	}
+	// 不修改msg底层数组中len之后的内容
+	return append(msg[:len(msg):len(msg)], d.Delimiter...), nil
+}
+
+// FixedLengthCodec 固定长度的帧
+type FixedLengthCodec struct {
+	Length int
+}
+
+func (f *FixedLengthCodec) Decode(reader *bufio.Reader) ([]byte, error) {
+	msg := mcache.Malloc(f.Length)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		mcache.Free(msg)
+		return nil, err
+	}
+	return msg, nil
+}
+
+// Encode 长度不足时补0, 超出时返回错误
+func (f *FixedLengthCodec) Encode(msg []byte) ([]byte, error) {
+	if len(msg) > f.Length {
+		return nil, fmt.Errorf("%w: length %d", ErrFrameTooLarge, len(msg))
+	}
+	if len(msg) < f.Length {
+		msg = append(msg[:len(msg):len(msg)], make([]byte, f.Length-len(msg))...)
+	}
+	return msg, nil
+}
@@ -0,0 +1,127 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeaderCodec(t *testing.T) {
+	codec := NewHeaderCodec(1, 4, 0x68)
+	stream := []byte{0x68, 0x02, 0x00, 0x00, 0xAA, 0xBB, 0x68, 0x00, 0x01, 0x02}
+	reader := bufio.NewReader(bytes.NewReader(stream))
+
+	msg, err := codec.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, stream[:6], msg)
+	msg, err = codec.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, stream[6:], msg)
+
+	reader = bufio.NewReader(bytes.NewReader([]byte{0x00, 0x68, 0x00, 0x00, 0x00}))
+	_, err = codec.Decode(reader)
+	assert.True(t, errors.Is(err, ErrInvalidFrame))
+}
+
+func TestLengthFieldCodec(t *testing.T) {
+	// 两字节大端长度, 长度包含整个帧, 帧尾两字节校验
+	codec := &LengthFieldCodec{
+		Start:        []byte{0xAA, 0xF5},
+		LengthOffset: 2,
+		LengthSize:   2,
+		ByteOrder:    binary.BigEndian,
+	}
+	frame := []byte{0xAA, 0xF5, 0x00, 0x00, 0x01, 0x02, 0x03, 0x04}
+	encoded, err := codec.Encode(frame)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0x00, 0x08}, encoded[2:4])
+
+	msg, err := codec.Decode(bufio.NewReader(bytes.NewReader(encoded)))
+	assert.Nil(t, err)
+	assert.Equal(t, encoded, msg)
+
+	codec.MaxFrameLength = 4
+	_, err = codec.Decode(bufio.NewReader(bytes.NewReader(encoded)))
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+}
+
+func TestDelimiterCodec(t *testing.T) {
+	codec := &DelimiterCodec{Delimiter: []byte("\r\n"), Strip: true}
+	reader := bufio.NewReader(bytes.NewReader([]byte("a\rb\r\ncd\r\n")))
+	msg, err := codec.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "a\rb", string(msg))
+	msg, err = codec.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, "cd", string(msg))
+
+	encoded, err := codec.Encode([]byte("ef"))
+	assert.Nil(t, err)
+	assert.Equal(t, "ef\r\n", string(encoded))
+	// 不覆盖调用方切片len之后的内容
+	buf := []byte("efgh")
+	encoded, err = codec.Encode(buf[:2])
+	assert.Nil(t, err)
+	assert.Equal(t, "ef\r\n", string(encoded))
+	assert.Equal(t, "efgh", string(buf))
+}
+
+func TestFixedLengthCodec(t *testing.T) {
+	codec := &FixedLengthCodec{Length: 3}
+	reader := bufio.NewReader(bytes.NewReader([]byte{1, 2, 3, 4, 5, 6}))
+	msg, err := codec.Decode(reader)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 2, 3}, msg)
+
+	encoded, err := codec.Encode([]byte{1})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{1, 0, 0}, encoded)
+	_, err = codec.Encode([]byte{1, 2, 3, 4})
+	assert.True(t, errors.Is(err, ErrFrameTooLarge))
+}
+
+func TestLengthFieldCodecResync(t *testing.T) {
+	codec := NewHeaderCodec(1, 4, 0x68)
+	codec.Resync = true
+	codec.Verify = NewCheckSumVerify(0)
+	frame := []byte{0x68, 0x01, 0x01}
+	frame = append(frame, lib.CheckSum(frame)...)
+	// 噪声中包含一个伪造的起始符
+	stream := append([]byte{0xFF, 0x00, 0x68, 0x00, 0x02, 0x03, 0x04}, frame...)
+	stream = append(stream, frame...)
+	reader := bufio.NewReader(bytes.NewReader(stream))
+
+	discarded := 0
+	var frames [][]byte
+	for {
+		msg, err := codec.Decode(reader)
+		var discardErr *DiscardError
+		if errors.As(err, &discardErr) {
+			discarded += discardErr.Discarded
+			continue
+		}
+		if err != nil {
+			break
+		}
+		frames = append(frames, msg)
+	}
+	assert.Equal(t, 7, discarded)
+	assert.Equal(t, [][]byte{frame, frame}, frames)
+}
+
+func TestCheckVerify(t *testing.T) {
+	// 0x68 + 数据 + CRC16/CCITT-FALSE + 0x16
+	check := lib.NewCRC16(lib.CRC16CCITTFalse, nil)
+	verify := NewCheckVerify(check, 1, 1)
+	frame := []byte{0x68, 0x01, 0x02}
+	frame = append(append(frame, check.Generate(frame[1:])...), 0x16)
+	assert.True(t, verify(frame))
+	frame[1] = 0x00
+	assert.False(t, verify(frame))
+	assert.False(t, verify([]byte{0x68, 0x16}))
+}
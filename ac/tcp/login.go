@@ -0,0 +1,98 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/bytedance/gopkg/lang/mcache"
+)
+
+// defaultLoginTimeout 等待登录报文的默认时间
+const defaultLoginTimeout = 30 * time.Second
+
+// Login 桩连接后发送的第一个报文为登录报文, 认证通过后才创建客户端
+type Login struct {
+	// Parse 从登录报文中解析认证信息, 不是登录报文时返回错误
+	Parse func(frame []byte) (*lib.Credentials, error)
+	// Reply 登录结果的回复报文, err不为空表示拒绝, 返回空时不回复
+	Reply func(frame []byte, identity *lib.Identity, err error) []byte
+	// Timeout 等待登录报文的时间, 为0时使用30秒
+	Timeout time.Duration
+}
+
+// Authenticate 读取登录报文并使用hub的Authenticator认证, 认证失败时回复桩并关闭连接,
+// 成功时返回的连接包含登录报文之后已经读取的数据, 应该用它创建Client, 然后调用Identity.Apply
+func (l *Login) Authenticate(hub *lib.Hub, conn net.Conn, decoder FrameDecoder, encoder FrameEncoder) (net.Conn, *lib.Identity, error) {
+	timeout := l.Timeout
+	if timeout <= 0 {
+		timeout = defaultLoginTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+	decoded, err := decoder.Decode(reader)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	// 解码的报文通过mcache分配, 复制后归还
+	frame := append([]byte(nil), decoded...)
+	mcache.Free(decoded)
+
+	var identity *lib.Identity
+	credentials, err := l.Parse(frame)
+	if err == nil {
+		credentials.RemoteAddress = conn.RemoteAddr().String()
+		if tlsConn, ok := conn.(*tls.Conn); ok {
+			state := tlsConn.ConnectionState()
+			credentials.SetPeerCertificate(&state)
+		}
+		if _, port, e := net.SplitHostPort(conn.LocalAddr().String()); e == nil {
+			credentials.Port = port
+		}
+		identity, err = hub.Authenticate(context.Background(), credentials)
+	} else {
+		// 不是登录报文也算认证失败
+		hub.AddAuthFailure()
+		err = fmt.Errorf("%w: %s", lib.ErrAuthentication, err.Error())
+	}
+	l.reply(conn, encoder, frame, identity, err)
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return &bufferedConn{Conn: conn, reader: reader}, identity, nil
+}
+
+func (l *Login) reply(conn net.Conn, encoder FrameEncoder, frame []byte, identity *lib.Identity, err error) {
+	if l.Reply == nil {
+		return
+	}
+	msg := l.Reply(frame, identity, err)
+	if msg == nil {
+		return
+	}
+	if encoder != nil {
+		if msg, err = encoder.Encode(msg); err != nil {
+			return
+		}
+	}
+	_ = conn.SetWriteDeadline(time.Now().Add(writeWait))
+	_, _ = conn.Write(msg)
+	_ = conn.SetWriteDeadline(time.Time{})
+}
+
+// bufferedConn 读取时先返回登录时已经缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
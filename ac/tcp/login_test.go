@@ -0,0 +1,76 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/protocol/interfaces"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogin(t *testing.T) {
+	hub := &lib.Hub{}
+	hub.SetAuthenticator(lib.AuthenticatorFunc(func(ctx context.Context, credentials *lib.Credentials) (*lib.Identity, error) {
+		if credentials.Password != "secret" {
+			return nil, errors.New("wrong password")
+		}
+		return &lib.Identity{ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, true, 1), KeepAlive: 30}, nil
+	}))
+	codec := &DelimiterCodec{Delimiter: []byte("\n"), Strip: true}
+	login := &Login{
+		Parse: func(frame []byte) (*lib.Credentials, error) {
+			fields := strings.Fields(string(frame))
+			if len(fields) != 3 || fields[0] != "login" {
+				return nil, errors.New("not a login frame")
+			}
+			return &lib.Credentials{SN: fields[1], Password: fields[2]}, nil
+		},
+		Reply: func(frame []byte, identity *lib.Identity, err error) []byte {
+			if err != nil {
+				return []byte("rejected")
+			}
+			return []byte("ok")
+		},
+	}
+	authenticate := func(frames string) (net.Conn, *lib.Identity, string, error) {
+		charger, gateway := net.Pipe()
+		defer charger.Close()
+		go func() {
+			_, _ = charger.Write([]byte(frames))
+		}()
+		type result struct {
+			conn     net.Conn
+			identity *lib.Identity
+			err      error
+		}
+		done := make(chan result, 1)
+		go func() {
+			conn, identity, err := login.Authenticate(hub, gateway, codec, codec)
+			done <- result{conn, identity, err}
+		}()
+		reply, _ := bufio.NewReader(charger).ReadString('\n')
+		r := <-done
+		return r.conn, r.identity, reply, r.err
+	}
+
+	_, _, reply, err := authenticate("login sn1 wrong\n")
+	assert.True(t, errors.Is(err, lib.ErrAuthentication))
+	assert.Equal(t, "rejected\n", reply)
+	_, _, _, err = authenticate("heartbeat\n")
+	assert.True(t, errors.Is(err, lib.ErrAuthentication))
+	assert.Equal(t, uint64(2), hub.Stats().AuthFailures)
+
+	conn, identity, reply, err := authenticate("login sn1 secret\nheartbeat\n")
+	assert.Nil(t, err)
+	assert.Equal(t, "ok\n", reply)
+	assert.Equal(t, "sn1", identity.ChargeStation.SN())
+	// 登录报文之后的数据可以从返回的连接中读取
+	frame, err := codec.Decode(bufio.NewReader(conn))
+	assert.Nil(t, err)
+	assert.Equal(t, "heartbeat", string(frame))
+}
@@ -0,0 +1,167 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidProxyHeader 连接的开头不是合法的PROXY协议头
+var ErrInvalidProxyHeader = errors.New("invalid proxy protocol header")
+
+var (
+	proxyV1Prefix = []byte("PROXY ")
+	proxyV2Prefix = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// proxyV1MaxLength v1协议头的最大长度, 包括结尾的\r\n
+const proxyV1MaxLength = 107
+
+// readProxyHeader 读取HAProxy PROXY协议v1或者v2的协议头, 返回桩的地址以及桩连接的地址,
+// 负载均衡的健康检查(v1的UNKNOWN, v2的LOCAL)返回空的地址
+func readProxyHeader(r *bufio.Reader) (source, destination net.Addr, err error) {
+	prefix, err := r.Peek(len(proxyV1Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(prefix, proxyV1Prefix) {
+		return readProxyV1(r)
+	}
+	if prefix, err = r.Peek(len(proxyV2Prefix)); err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(prefix, proxyV2Prefix) {
+		return readProxyV2(r)
+	}
+	return nil, nil, ErrInvalidProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, nil, fmt.Errorf("%w: v1 header too long", ErrInvalidProxyHeader)
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, fmt.Errorf("%w: v1 header not end with CRLF", ErrInvalidProxyHeader)
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidProxyHeader, line)
+	}
+	source, err := proxyTCPAddr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	destination, err := proxyTCPAddr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return source, destination, nil
+}
+
+func proxyTCPAddr(ip, port string) (*net.TCPAddr, error) {
+	addr := &net.TCPAddr{IP: net.ParseIP(ip)}
+	if addr.IP == nil {
+		return nil, fmt.Errorf("%w: invalid ip %s", ErrInvalidProxyHeader, ip)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid port %s", ErrInvalidProxyHeader, port)
+	}
+	addr.Port = int(p)
+	return addr, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, net.Addr, error) {
+	header := make([]byte, len(proxyV2Prefix)+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, nil, err
+	}
+	versionCommand, family := header[12], header[13]
+	length := int(binary.BigEndian.Uint16(header[14:]))
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+	if versionCommand>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidProxyHeader, versionCommand>>4)
+	}
+	// LOCAL命令是负载均衡自己建立的连接
+	if versionCommand&0x0f == 0 {
+		return nil, nil, nil
+	}
+	if versionCommand&0x0f != 1 {
+		return nil, nil, fmt.Errorf("%w: unsupported command %d", ErrInvalidProxyHeader, versionCommand&0x0f)
+	}
+	var ipLength int
+	switch family >> 4 {
+	case 1:
+		ipLength = net.IPv4len
+	case 2:
+		ipLength = net.IPv6len
+	default:
+		// 不支持的地址类型按照UNSPEC处理
+		return nil, nil, nil
+	}
+	// 充电桩只通过TCP连接, 低4位必须是STREAM
+	if family&0x0f != 1 {
+		return nil, nil, fmt.Errorf("%w: unsupported transport %d", ErrInvalidProxyHeader, family&0x0f)
+	}
+	if length < ipLength*2+4 {
+		return nil, nil, fmt.Errorf("%w: address too short", ErrInvalidProxyHeader)
+	}
+	source := &net.TCPAddr{
+		IP:   net.IP(payload[:ipLength]),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2:])),
+	}
+	destination := &net.TCPAddr{
+		IP:   net.IP(payload[ipLength : ipLength*2]),
+		Port: int(binary.BigEndian.Uint16(payload[ipLength*2+2:])),
+	}
+	return source, destination, nil
+}
+
+// proxyConn 使用PROXY协议头中的地址作为连接的地址
+type proxyConn struct {
+	net.Conn
+	reader      *bufio.Reader
+	source      net.Addr
+	destination net.Addr
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	if c.destination != nil {
+		return c.destination
+	}
+	return c.Conn.LocalAddr()
+}
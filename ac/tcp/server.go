@@ -0,0 +1,325 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"golang.org/x/time/rate"
+)
+
+// ErrServerClosed Serve在Close之后返回的错误
+var ErrServerClosed = errors.New("tcp: server closed")
+
+const (
+	// defaultProxyHeaderTimeout 等待PROXY协议头的默认时间
+	defaultProxyHeaderTimeout = 5 * time.Second
+	// defaultHandshakeTimeout TLS握手的默认时间
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+// Server 接受桩的tcp连接, 创建Client并启动
+type Server struct {
+	Hub     *lib.Hub
+	Log     *rabbitmq.Logger
+	Decoder FrameDecoder
+	Encoder FrameEncoder
+	// KeepAlive 认证结果没有心跳时间时使用
+	KeepAlive int64
+	// MaxConnections 同时连接的最大数量, 为0时不限制, 超过时直接关闭新的连接
+	MaxConnections int
+	// AcceptRate 同一个IP每秒允许建立的连接数, 为0时不限制
+	AcceptRate float64
+	// AcceptBurst 同一个IP允许突发建立的连接数, 为0时为1
+	AcceptBurst int
+	// ProxyProtocol 连接的开头为HAProxy PROXY协议头(v1或v2), 使用其中的地址作为桩的地址
+	ProxyProtocol bool
+	// ProxyHeaderTimeout 等待PROXY协议头的时间, 为0时为5秒
+	ProxyHeaderTimeout time.Duration
+	// TLSConfig 不为空时使用TLS, 可以使用certs.ServerConfig开启双向认证, 客户端证书的序列号设置为CertificateSN
+	TLSConfig *tls.Config
+	// HandshakeTimeout TLS握手的时间, 为0时为10秒
+	HandshakeTimeout time.Duration
+	// Login 不为空时先认证, 然后启动SubMQTT(未注册的桩为SubRegMQTT),
+	// 为空时只启动ReadPump和WritePump, 由协议翻译识别桩后再订阅
+	Login *Login
+	// OnConnect 客户端启动之前调用, 可以设置离线通知等, 默认的离线通知不做任何处理
+	OnConnect func(client lib.ClientInterface)
+	// Cipher 不为空时按帧加密解密, 桩交换密钥之前使用lib.EncryptTypeNone
+	Cipher *Cipher
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	limiters  map[string]*limiter
+	closed    bool
+	// connections 当前的连接数
+	connections int64
+	// rejected 超过连接数或者频率限制被拒绝的连接数
+	rejected uint64
+}
+
+type limiter struct {
+	*rate.Limiter
+	lastSeen time.Time
+}
+
+// NewServer 使用decoder拆分桩上传的报文, encoder为nil时发送的报文不做处理
+func NewServer(hub *lib.Hub, log *rabbitmq.Logger, decoder FrameDecoder, encoder FrameEncoder) *Server {
+	return &Server{Hub: hub, Log: log, Decoder: decoder, Encoder: encoder}
+}
+
+// ListenAndServe 监听addr并处理连接
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve 处理l上的连接, 直到Close或者l返回错误
+func (s *Server) Serve(l net.Listener) error {
+	if !s.track(l, true) {
+		_ = l.Close()
+		return ErrServerClosed
+	}
+	defer s.track(l, false)
+	stop := make(chan struct{})
+	defer close(stop)
+	if s.AcceptRate > 0 {
+		go s.cleanLimiters(stop)
+	}
+	var delay time.Duration
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				// 与net/http相同, 临时错误时等待后重试
+				if delay == 0 {
+					delay = 5 * time.Millisecond
+				} else if delay *= 2; delay > time.Second {
+					delay = time.Second
+				}
+				time.Sleep(delay)
+				continue
+			}
+			return err
+		}
+		delay = 0
+		go s.serve(conn)
+	}
+}
+
+// Close 停止接受新的连接, 已经建立的连接由Hub.Shutdown关闭
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	var err error
+	for l := range s.listeners {
+		if e := l.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// Connections 当前的连接数
+func (s *Server) Connections() int {
+	return int(atomic.LoadInt64(&s.connections))
+}
+
+// Rejected 超过连接数或者频率限制被拒绝的连接数
+func (s *Server) Rejected() uint64 {
+	return atomic.LoadUint64(&s.rejected)
+}
+
+func (s *Server) serve(conn net.Conn) {
+	if n := atomic.AddInt64(&s.connections, 1); s.MaxConnections > 0 && n > int64(s.MaxConnections) {
+		s.reject(conn, "too many connections")
+		return
+	}
+	var err error
+	if s.ProxyProtocol {
+		if conn, err = s.readProxyHeader(conn); err != nil {
+			s.reject(conn, err.Error())
+			return
+		}
+	}
+	if !s.allow(conn.RemoteAddr()) {
+		s.reject(conn, "accept rate exceeded")
+		return
+	}
+	if s.TLSConfig != nil {
+		if conn, err = s.handshake(conn); err != nil {
+			s.reject(conn, err.Error())
+			return
+		}
+	}
+
+	var identity *lib.Identity
+	if s.Login != nil {
+		remoteAddress := conn.RemoteAddr().String()
+		// 认证失败时Login已经关闭了连接
+		if conn, identity, err = s.Login.Authenticate(s.Hub, conn, s.Decoder, s.Encoder); err != nil {
+			atomic.AddInt64(&s.connections, -1)
+			s.Log.Sugar().Infof("reject %s, err:%s", remoteAddress, err.Error())
+			return
+		}
+	}
+	client := NewClient(s.Hub, conn, s.KeepAlive, conn.RemoteAddr().String(), s.Log, s.Decoder, s.Encoder)
+	if identity != nil {
+		identity.Apply(client)
+	} else if tlsConn, ok := conn.(*tls.Conn); ok {
+		var credentials lib.Credentials
+		state := tlsConn.ConnectionState()
+		credentials.SetPeerCertificate(&state)
+		client.SetCertificateSN(credentials.CertificateSN)
+	}
+	if s.Cipher != nil {
+		client.(*Client).SetCipher(s.Cipher)
+	}
+	if s.OnConnect != nil {
+		s.OnConnect(client)
+	}
+	// 客户端关闭后减少连接数, 离线通知在OnConnect中可能被替换, 这里包装一次
+	offline := client.ClientOfflineFunc()
+	client.SetClientOfflineFunc(func(err error) {
+		atomic.AddInt64(&s.connections, -1)
+		if offline != nil {
+			offline(err)
+		}
+	})
+	go client.ReadPump()
+	go client.WritePump()
+	if identity == nil {
+		return
+	}
+	if identity.ChargeStation.Registered() {
+		go client.SubMQTT()
+	} else {
+		go client.SubRegMQTT()
+	}
+}
+
+func (s *Server) reject(conn net.Conn, reason string) {
+	atomic.AddInt64(&s.connections, -1)
+	atomic.AddUint64(&s.rejected, 1)
+	s.Log.Sugar().Infof("reject %s, reason:%s", conn.RemoteAddr().String(), reason)
+	_ = conn.Close()
+}
+
+func (s *Server) handshake(conn net.Conn) (net.Conn, error) {
+	timeout := s.HandshakeTimeout
+	if timeout <= 0 {
+		timeout = defaultHandshakeTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tlsConn := tls.Server(conn, s.TLSConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return conn, err
+	}
+	return tlsConn, nil
+}
+
+func (s *Server) readProxyHeader(conn net.Conn) (net.Conn, error) {
+	timeout := s.ProxyHeaderTimeout
+	if timeout <= 0 {
+		timeout = defaultProxyHeaderTimeout
+	}
+	_ = conn.SetReadDeadline(time.Now().Add(timeout))
+	reader := bufio.NewReader(conn)
+	source, destination, err := readProxyHeader(reader)
+	if err != nil {
+		return conn, err
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+	return &proxyConn{Conn: conn, reader: reader, source: source, destination: destination}, nil
+}
+
+// allow 同一个IP建立连接的频率是否超过限制
+func (s *Server) allow(addr net.Addr) bool {
+	if s.AcceptRate <= 0 {
+		return true
+	}
+	ip := addr.String()
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limiters == nil {
+		s.limiters = make(map[string]*limiter)
+	}
+	l, ok := s.limiters[ip]
+	if !ok {
+		burst := s.AcceptBurst
+		if burst <= 0 {
+			burst = 1
+		}
+		l = &limiter{Limiter: rate.NewLimiter(rate.Limit(s.AcceptRate), burst)}
+		s.limiters[ip] = l
+	}
+	l.lastSeen = time.Now()
+	return l.Allow()
+}
+
+// cleanLimiters 定期删除一段时间内没有建立连接的IP
+func (s *Server) cleanLimiters(stop chan struct{}) {
+	// 令牌补满之后删除与新建的效果相同
+	idle := time.Duration(float64(s.AcceptBurst+1) / s.AcceptRate * float64(time.Second))
+	if idle < time.Minute {
+		idle = time.Minute
+	}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			s.mu.Lock()
+			for ip, l := range s.limiters {
+				if now.Sub(l.lastSeen) > idle {
+					delete(s.limiters, ip)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+func (s *Server) track(l net.Listener, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		if s.listeners == nil {
+			s.listeners = make(map[net.Listener]struct{})
+		}
+		s.listeners[l] = struct{}{}
+	} else {
+		delete(s.listeners, l)
+	}
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
@@ -0,0 +1,121 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	read := func(header []byte) (net.Addr, net.Addr, string, error) {
+		r := bufio.NewReader(bytes.NewReader(append(header, "data"...)))
+		source, destination, err := readProxyHeader(r)
+		rest, _ := r.ReadString(0)
+		return source, destination, rest, err
+	}
+
+	source, destination, rest, err := read([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 8080\r\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.1:56324", source.String())
+	assert.Equal(t, "10.0.0.1:8080", destination.String())
+	assert.Equal(t, "data", rest)
+
+	source, _, _, err = read([]byte("PROXY UNKNOWN\r\n"))
+	assert.Nil(t, err)
+	assert.Nil(t, source)
+
+	v2 := append([]byte(nil), proxyV2Prefix...)
+	v2 = append(v2, 0x21, 0x11, 0x00, 12, 192, 168, 0, 2, 10, 0, 0, 1)
+	v2 = binary.BigEndian.AppendUint16(v2, 40000)
+	v2 = binary.BigEndian.AppendUint16(v2, 8080)
+	source, destination, rest, err = read(v2)
+	assert.Nil(t, err)
+	assert.Equal(t, "192.168.0.2:40000", source.String())
+	assert.Equal(t, "10.0.0.1:8080", destination.String())
+	assert.Equal(t, "data", rest)
+
+	// 只接受STREAM, DGRAM的地址不是TCP连接
+	dgram := append([]byte(nil), v2...)
+	dgram[13] = 0x12
+	_, _, _, err = read(dgram)
+	assert.True(t, errors.Is(err, ErrInvalidProxyHeader))
+
+	// LOCAL命令为负载均衡的健康检查
+	local := append(append([]byte(nil), proxyV2Prefix...), 0x20, 0x00, 0x00, 0x00)
+	source, _, rest, err = read(local)
+	assert.Nil(t, err)
+	assert.Nil(t, source)
+	assert.Equal(t, "data", rest)
+
+	_, _, _, err = read([]byte("GET / HTTP/1.1\r\n"))
+	assert.True(t, errors.Is(err, ErrInvalidProxyHeader))
+	_, _, _, err = read([]byte("PROXY TCP4 x 10.0.0.1 1 2\r\n"))
+	assert.True(t, errors.Is(err, ErrInvalidProxyHeader))
+}
+
+func TestServer(t *testing.T) {
+	hub := &lib.Hub{}
+	codec := &DelimiterCodec{Delimiter: []byte("\n"), Strip: true}
+	server := NewServer(hub, &rabbitmq.Logger{Logger: zap.NewNop()}, codec, codec)
+	server.MaxConnections = 2
+	server.AcceptRate = 0.001
+	server.AcceptBurst = 2
+	server.ProxyProtocol = true
+	connected := make(chan string, 4)
+	server.OnConnect = func(client lib.ClientInterface) {
+		// 离线通知为空时关闭连接不会panic
+		client.SetClientOfflineFunc(nil)
+		connected <- client.RemoteAddress()
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	dial := func(ip string) net.Conn {
+		conn, err := net.Dial("tcp", l.Addr().String())
+		assert.Nil(t, err)
+		_, err = conn.Write([]byte("PROXY TCP4 " + ip + " 127.0.0.1 40000 8080\r\n"))
+		assert.Nil(t, err)
+		return conn
+	}
+	closed := func(conn net.Conn) bool {
+		_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err := conn.Read(make([]byte, 1))
+		var ne net.Error
+		return err != nil && !(errors.As(err, &ne) && ne.Timeout())
+	}
+
+	// 桩的地址为PROXY协议头中的地址
+	c1 := dial("192.168.0.1")
+	assert.Equal(t, "192.168.0.1:40000", <-connected)
+	c2 := dial("192.168.0.1")
+	assert.Equal(t, "192.168.0.1:40000", <-connected)
+	// 超过最大连接数
+	c3 := dial("192.168.0.2")
+	assert.True(t, closed(c3))
+	c2.Close()
+	assert.Eventually(t, func() bool { return server.Connections() == 1 }, time.Second, time.Millisecond)
+	// 同一个IP超过频率限制
+	c4 := dial("192.168.0.1")
+	assert.True(t, closed(c4))
+	c5 := dial("192.168.0.3")
+	assert.Equal(t, "192.168.0.3:40000", <-connected)
+	assert.Equal(t, uint64(2), server.Rejected())
+
+	assert.Nil(t, server.Close())
+	assert.Equal(t, ErrServerClosed, <-served)
+	c1.Close()
+	c5.Close()
+}
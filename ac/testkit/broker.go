@@ -0,0 +1,261 @@
+// Package testkit 离线测试协议翻译器使用的内存MQTT broker以及桩模拟器
+//
+// 典型的用法:
+//
+//	broker := testkit.NewBroker()
+//	hub, _ := lib.NewHubWithOptions("ocpp", "1.6", lib.WithTransport(broker.Transport("gateway")))
+//	charger, conn := testkit.NewTCPCharger(decoder)
+//	client := tcp.NewClient(hub, conn, 60, "127.0.0.1", log, decoder, nil)
+//	...
+//	charger.Send(frame)
+//	m, err := broker.Expect("coregw/+/command/#", time.Second)
+package testkit
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	mqttClient "github.com/eclipse/paho.mqtt.golang"
+)
+
+var (
+	ErrOffline = errors.New("broker is offline")
+	ErrTimeout = errors.New("timeout")
+)
+
+// Broker 内存中的MQTT broker, 支持+和#通配符以及$share/共享订阅
+//
+// Broker记录所有发布的消息, 测试可以通过Expect等待平台收到的消息.
+type Broker struct {
+	mu     sync.Mutex
+	online bool
+	// onDisconnect broker离线时调用
+	onDisconnect  []func(err error)
+	subscriptions []*subscription
+	// shared 共享订阅的轮询位置
+	shared   map[string]int
+	messages []mqtt.MqttMessage
+	// notify 收到新消息时关闭
+	notify chan struct{}
+}
+
+type subscription struct {
+	client   *Client
+	filter   string
+	group    string
+	qos      byte
+	callback mqttClient.MessageHandler
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		online: true,
+		shared: make(map[string]int),
+		notify: make(chan struct{}),
+	}
+}
+
+// Client 创建一个连接到broker的客户端, 实现了paho的mqtt.Client
+func (b *Broker) Client(clientID string) *Client {
+	c := &Client{
+		id:     clientID,
+		broker: b,
+		queue:  make(chan delivery, 1024),
+		done:   make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// SetOnline 模拟broker断开以及恢复, 离线时所有的发布都会失败
+func (b *Broker) SetOnline(online bool) {
+	b.mu.Lock()
+	changed := b.online != online
+	b.online = online
+	onDisconnect := append([]func(err error){}, b.onDisconnect...)
+	b.mu.Unlock()
+	if changed && !online {
+		for _, f := range onDisconnect {
+			f(ErrOffline)
+		}
+	}
+}
+
+// Transport 创建一个连接到broker的lib.Transport, broker离线时调用OnDisconnect注册的函数
+func (b *Broker) Transport(clientID string) lib.Transport {
+	return &transport{
+		MQTTTransport: lib.WrapMQTTTransport(mqtt.WrapMQTTClient(b.Client(clientID))),
+		broker:        b,
+	}
+}
+
+type transport struct {
+	*lib.MQTTTransport
+	broker *Broker
+}
+
+func (t *transport) OnDisconnect(f func(err error)) {
+	t.broker.mu.Lock()
+	defer t.broker.mu.Unlock()
+	t.broker.onDisconnect = append(t.broker.onDisconnect, f)
+}
+
+// Online broker是否在线
+func (b *Broker) Online() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.online
+}
+
+// Publish 以平台的身份发布消息
+func (b *Broker) Publish(topic string, payload []byte) error {
+	return b.publish(mqtt.MqttMessage{Topic: topic, Qos: 2, Payload: payload})
+}
+
+// Messages 所有发布过的消息
+func (b *Broker) Messages() []mqtt.MqttMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]mqtt.MqttMessage(nil), b.messages...)
+}
+
+// Expect 等待第一条匹配filter的消息, 返回的消息会被移除, 之后的Expect不会再返回
+func (b *Broker) Expect(filter string, timeout time.Duration) (mqtt.MqttMessage, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		b.mu.Lock()
+		for i, m := range b.messages {
+			if Match(filter, m.Topic) {
+				b.messages = append(b.messages[:i:i], b.messages[i+1:]...)
+				b.mu.Unlock()
+				return m, nil
+			}
+		}
+		notify := b.notify
+		b.mu.Unlock()
+		select {
+		case <-notify:
+		case <-timer.C:
+			return mqtt.MqttMessage{}, fmt.Errorf("expect %s: %w", filter, ErrTimeout)
+		}
+	}
+}
+
+// Subscribed 是否有客户端订阅了topic, 可以用来等待Hub.Run订阅完成
+func (b *Broker) Subscribed(topic string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subscriptions {
+		if Match(s.filter, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Broker) publish(m mqtt.MqttMessage) error {
+	b.mu.Lock()
+	if !b.online {
+		b.mu.Unlock()
+		return ErrOffline
+	}
+	b.messages = append(b.messages, m)
+	close(b.notify)
+	b.notify = make(chan struct{})
+
+	var matched []*subscription
+	groups := make(map[string][]*subscription)
+	for _, s := range b.subscriptions {
+		if !Match(s.filter, m.Topic) {
+			continue
+		}
+		if s.group == "" {
+			matched = append(matched, s)
+			continue
+		}
+		groups[s.group+"/"+s.filter] = append(groups[s.group+"/"+s.filter], s)
+	}
+	// 共享订阅只投递给组内的一个客户端
+	for key, subs := range groups {
+		matched = append(matched, subs[b.shared[key]%len(subs)])
+		b.shared[key]++
+	}
+	b.mu.Unlock()
+
+	// 回调中可能再次发布消息, 投递时不能持有锁
+	for _, s := range matched {
+		s.client.deliver(s, m)
+	}
+	return nil
+}
+
+func (b *Broker) subscribe(c *Client, filter string, qos byte, callback mqttClient.MessageHandler) {
+	var group string
+	switch {
+	case strings.HasPrefix(filter, mqtt.SharePrefix()):
+		parts := strings.SplitN(strings.TrimPrefix(filter, mqtt.SharePrefix()), "/", 2)
+		if len(parts) == 2 {
+			group, filter = parts[0], parts[1]
+		}
+	case strings.HasPrefix(filter, mqtt.QueuePrefix()):
+		group, filter = mqtt.QueuePrefix(), strings.TrimPrefix(filter, mqtt.QueuePrefix())
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, &subscription{
+		client:   c,
+		filter:   filter,
+		group:    group,
+		qos:      qos,
+		callback: callback,
+	})
+}
+
+func (b *Broker) unsubscribe(c *Client, filters ...string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	subscriptions := b.subscriptions[:0]
+	for _, s := range b.subscriptions {
+		if s.client == c && (len(filters) == 0 || contains(filters, s.filter, s.group)) {
+			continue
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	b.subscriptions = subscriptions
+}
+
+func contains(filters []string, filter, group string) bool {
+	for _, f := range filters {
+		switch {
+		case group == "" && f == filter,
+			group == mqtt.QueuePrefix() && f == group+filter,
+			f == mqtt.SharePrefix()+group+"/"+filter:
+			return true
+		}
+	}
+	return false
+}
+
+// Match topic是否匹配订阅的filter, 支持+和#通配符
+func Match(filter, topic string) bool {
+	filters := strings.Split(filter, "/")
+	topics := strings.Split(topic, "/")
+	for i, f := range filters {
+		if f == "#" {
+			return true
+		}
+		if i >= len(topics) {
+			return false
+		}
+		if f != "+" && f != topics[i] {
+			return false
+		}
+	}
+	return len(filters) == len(topics)
+}
@@ -0,0 +1,185 @@
+package testkit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/tcp"
+	"github.com/gorilla/websocket"
+)
+
+// Charger 桩模拟器
+type Charger interface {
+	// Send 发送报文给网关
+	Send(frame []byte) error
+	// Receive 等待网关发送的下一个报文
+	Receive(timeout time.Duration) ([]byte, error)
+	Close() error
+}
+
+// Step 脚本中的一步, Send不为空时先发送, Expect不为空时再等待网关的报文并校验
+type Step struct {
+	Send   []byte
+	Expect func(frame []byte) error
+}
+
+// Run 按顺序执行脚本, 每一步等待网关报文的超时时间为timeout
+func Run(c Charger, steps []Step, timeout time.Duration) error {
+	for i, step := range steps {
+		if step.Send != nil {
+			if err := c.Send(step.Send); err != nil {
+				return fmt.Errorf("step %d: %w", i, err)
+			}
+		}
+		if step.Expect == nil {
+			continue
+		}
+		frame, err := c.Receive(timeout)
+		if err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+		if err = step.Expect(frame); err != nil {
+			return fmt.Errorf("step %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// receiver 在单独的goroutine中读取报文, 避免网关写入时阻塞
+type receiver struct {
+	frames chan []byte
+	once   sync.Once
+	err    error
+}
+
+func newReceiver() *receiver {
+	return &receiver{frames: make(chan []byte, 64)}
+}
+
+func (r *receiver) receive(timeout time.Duration) ([]byte, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case frame, ok := <-r.frames:
+		if !ok {
+			return nil, r.err
+		}
+		return frame, nil
+	case <-timer.C:
+		return nil, fmt.Errorf("receive: %w", ErrTimeout)
+	}
+}
+
+func (r *receiver) stop(err error) {
+	r.once.Do(func() {
+		r.err = err
+		close(r.frames)
+	})
+}
+
+// TCPCharger 通过内存连接与tcp.Client通信的桩模拟器
+type TCPCharger struct {
+	conn net.Conn
+	*receiver
+}
+
+// NewTCPCharger 返回模拟器以及网关一侧的连接, 网关一侧的连接用于创建tcp.Client,
+// decoder用于拆分网关发送的报文
+func NewTCPCharger(decoder tcp.FrameDecoder) (*TCPCharger, net.Conn) {
+	charger, gateway := net.Pipe()
+	c := &TCPCharger{conn: charger, receiver: newReceiver()}
+	go func() {
+		reader := bufio.NewReader(charger)
+		for {
+			frame, err := decoder.Decode(reader)
+			if err != nil {
+				c.stop(err)
+				return
+			}
+			c.frames <- append([]byte(nil), frame...)
+		}
+	}()
+	return c, gateway
+}
+
+func (c *TCPCharger) Send(frame []byte) error {
+	_, err := c.conn.Write(frame)
+	return err
+}
+
+func (c *TCPCharger) Receive(timeout time.Duration) ([]byte, error) {
+	return c.receive(timeout)
+}
+
+func (c *TCPCharger) Close() error {
+	return c.conn.Close()
+}
+
+// NewWebsocketServer 启动本地的websocket服务, 每个连接调用handler创建websocket.Client,
+// handler返回后连接不会被关闭
+func NewWebsocketServer(handler func(conn *websocket.Conn, r *http.Request)) *httptest.Server {
+	upgrader := websocket.Upgrader{
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		handler(conn, r)
+	}))
+}
+
+// WebsocketCharger websocket桩模拟器
+type WebsocketCharger struct {
+	conn *websocket.Conn
+	mu   sync.Mutex
+	*receiver
+}
+
+// DialWebsocket 连接到url, url可以是http://开头的测试服务地址
+func DialWebsocket(url string, header http.Header) (*WebsocketCharger, error) {
+	url = strings.Replace(url, "http", "ws", 1)
+	conn, _, err := websocket.DefaultDialer.Dial(url, header)
+	if err != nil {
+		return nil, err
+	}
+	c := &WebsocketCharger{conn: conn, receiver: newReceiver()}
+	go func() {
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				c.stop(err)
+				return
+			}
+			// 网关会把排队的多个报文用换行合并成一条消息
+			for _, frame := range bytes.Split(message, []byte{'\n'}) {
+				if len(frame) > 0 {
+					c.frames <- frame
+				}
+			}
+		}
+	}()
+	return c, nil
+}
+
+func (c *WebsocketCharger) Send(frame []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn.WriteMessage(websocket.TextMessage, frame)
+}
+
+func (c *WebsocketCharger) Receive(timeout time.Duration) ([]byte, error) {
+	return c.receive(timeout)
+}
+
+func (c *WebsocketCharger) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,195 @@
+package testkit
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	mqttClient "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Client 连接到内存broker的MQTT客户端
+//
+// 每个客户端在单独的goroutine中按顺序调用订阅的回调, 与paho的行为一致.
+type Client struct {
+	id     string
+	broker *Broker
+
+	mu           sync.Mutex
+	disconnected bool
+	routes       map[string]mqttClient.MessageHandler
+
+	queue chan delivery
+	done  chan struct{}
+	once  sync.Once
+}
+
+type delivery struct {
+	callback mqttClient.MessageHandler
+	message  *message
+}
+
+var _ mqttClient.Client = (*Client)(nil)
+
+func (c *Client) IsConnected() bool {
+	return c.IsConnectionOpen()
+}
+
+func (c *Client) IsConnectionOpen() bool {
+	c.mu.Lock()
+	disconnected := c.disconnected
+	c.mu.Unlock()
+	return !disconnected && c.broker.Online()
+}
+
+func (c *Client) Connect() mqttClient.Token {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.disconnected {
+		return newToken(fmt.Errorf("client %s is disconnected", c.id))
+	}
+	return newToken(nil)
+}
+
+// Disconnect 断开后客户端不能再使用
+func (c *Client) Disconnect(quiesce uint) {
+	c.mu.Lock()
+	c.disconnected = true
+	c.mu.Unlock()
+	c.broker.unsubscribe(c)
+	c.once.Do(func() {
+		close(c.done)
+	})
+}
+
+func (c *Client) Publish(topic string, qos byte, retained bool, payload interface{}) mqttClient.Token {
+	if !c.IsConnectionOpen() {
+		return newToken(ErrOffline)
+	}
+	var data []byte
+	switch p := payload.(type) {
+	case []byte:
+		data = append([]byte(nil), p...)
+	case string:
+		data = []byte(p)
+	case bytes.Buffer:
+		data = p.Bytes()
+	default:
+		return newToken(fmt.Errorf("unknown payload type %T", payload))
+	}
+	return newToken(c.broker.publish(mqtt.MqttMessage{
+		Topic:    topic,
+		Qos:      qos,
+		Retained: retained,
+		Payload:  data,
+	}))
+}
+
+func (c *Client) Subscribe(topic string, qos byte, callback mqttClient.MessageHandler) mqttClient.Token {
+	return c.SubscribeMultiple(map[string]byte{topic: qos}, callback)
+}
+
+func (c *Client) SubscribeMultiple(filters map[string]byte, callback mqttClient.MessageHandler) mqttClient.Token {
+	if !c.IsConnectionOpen() {
+		return newToken(ErrOffline)
+	}
+	for filter, qos := range filters {
+		c.broker.subscribe(c, filter, qos, callback)
+	}
+	return newToken(nil)
+}
+
+func (c *Client) Unsubscribe(topics ...string) mqttClient.Token {
+	if len(topics) > 0 {
+		c.broker.unsubscribe(c, topics...)
+	}
+	return newToken(nil)
+}
+
+// AddRoute 回调为空的订阅使用匹配的路由处理
+func (c *Client) AddRoute(topic string, callback mqttClient.MessageHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.routes == nil {
+		c.routes = make(map[string]mqttClient.MessageHandler)
+	}
+	c.routes[topic] = callback
+}
+
+// OptionsReader 内存客户端没有配置, 返回空的ClientOptionsReader, 不能调用它的方法
+func (c *Client) OptionsReader() mqttClient.ClientOptionsReader {
+	return mqttClient.ClientOptionsReader{}
+}
+
+func (c *Client) deliver(s *subscription, m mqtt.MqttMessage) {
+	callback := s.callback
+	if callback == nil {
+		c.mu.Lock()
+		for filter, route := range c.routes {
+			if Match(filter, m.Topic) {
+				callback = route
+				break
+			}
+		}
+		c.mu.Unlock()
+	}
+	if callback == nil {
+		return
+	}
+	qos := m.Qos
+	if s.qos < qos {
+		qos = s.qos
+	}
+	select {
+	case c.queue <- delivery{callback: callback, message: &message{topic: m.Topic, qos: qos, retained: m.Retained, payload: m.Payload}}:
+	case <-c.done:
+	}
+}
+
+func (c *Client) run() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case d := <-c.queue:
+			d.callback(c, d.message)
+		}
+	}
+}
+
+type message struct {
+	topic    string
+	qos      byte
+	retained bool
+	payload  []byte
+}
+
+func (m *message) Duplicate() bool   { return false }
+func (m *message) Qos() byte         { return m.qos }
+func (m *message) Retained() bool    { return m.retained }
+func (m *message) Topic() string     { return m.topic }
+func (m *message) MessageID() uint16 { return 0 }
+func (m *message) Payload() []byte   { return m.payload }
+func (m *message) Ack()              {}
+
+// token 内存broker的操作都是同步完成的
+type token struct {
+	err error
+}
+
+var closed = func() chan struct{} {
+	ch := make(chan struct{})
+	close(ch)
+	return ch
+}()
+
+func newToken(err error) *token {
+	return &token{err: err}
+}
+
+func (t *token) Wait() bool                       { return true }
+func (t *token) WaitTimeout(_ time.Duration) bool { return true }
+func (t *token) Done() <-chan struct{}            { return closed }
+func (t *token) Error() error                     { return t.err }
@@ -0,0 +1,341 @@
+package testkit
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/ac/spool"
+	"github.com/Kotodian/gokit/ac/tcp"
+	ws "github.com/Kotodian/gokit/ac/websocket"
+	"github.com/Kotodian/gokit/datasource"
+	"github.com/Kotodian/gokit/datasource/mqtt"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/Kotodian/protocol/golang/hardware/charger"
+	"github.com/Kotodian/protocol/interfaces"
+	mqttClient "github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+const coreID = 1
+
+// translator 桩上传的报文为心跳的内容, 平台下发的请求翻译成"command"
+type translator struct{}
+
+func (translator) ToAPDU(ctx context.Context, msg []byte) (proto.Message, error) {
+	trData, _ := lib.TRDataFromCtx(ctx)
+	trData.APDU = &charger.APDU{MessageId: charger.MessageID_ID_HeartbeatReq, SequenceId: 1}
+	return &charger.MessageError{Description: string(msg)}, nil
+}
+
+func (translator) FromAPDU(ctx context.Context, apdu *charger.APDU) (interface{}, error) {
+	if apdu.MessageId != charger.MessageID_ID_RemoteControlReq {
+		return nil, errors.New("not supported")
+	}
+	return "command", nil
+}
+
+func newHub(t *testing.T) (*Broker, *lib.Hub) {
+	broker := NewBroker()
+	hub, err := lib.NewHubWithOptions("test", "1.0", lib.WithTransport(broker.Transport("gateway")), lib.WithHostname("gateway"))
+	assert.Nil(t, err)
+	hub.SetTR(translator{})
+	hub.CommandFn = func(ctx context.Context, payload interface{}) ([]byte, error) {
+		return []byte(payload.(string)), nil
+	}
+	go hub.Run()
+	t.Cleanup(func() {
+		// 桩模拟器断开后客户端自行关闭, 再关闭网关
+		deadline := time.Now().Add(time.Second)
+		for hub.Stats().Connected > 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+		_ = hub.Shutdown(context.Background())
+	})
+	assert.Eventually(t, func() bool { return broker.Subscribed(hub.Hostname + "/command/x") }, time.Second, time.Millisecond)
+	return broker, hub
+}
+
+func run(client lib.ClientInterface) {
+	client.SetClientOfflineFunc(func(err error) {})
+	go client.ReadPump()
+	go client.WritePump()
+	go client.SubMQTT()
+}
+
+// roundTrip 桩上传报文后平台收到APDU, 平台下发请求后桩收到报文
+func roundTrip(t *testing.T, broker *Broker, hub *lib.Hub, c Charger, expected string) {
+	uuid := datasource.UUID(coreID).String()
+	assert.Eventually(t, func() bool { return broker.Subscribed(hub.Hostname + "/command/" + uuid) }, time.Second, time.Millisecond)
+	assert.Nil(t, c.Send([]byte("heartbeat\n")))
+	m, err := broker.Expect("coregw/"+hub.Hostname+"/command/"+uuid, time.Second)
+	assert.Nil(t, err)
+	var apdu charger.APDU
+	assert.Nil(t, proto.Unmarshal(m.Payload, &apdu))
+	assert.Equal(t, charger.MessageID_ID_HeartbeatReq, apdu.MessageId)
+	var payload charger.MessageError
+	assert.Nil(t, proto.Unmarshal(apdu.Payload, &payload))
+	assert.Equal(t, "heartbeat", payload.Description)
+
+	command, _ := proto.Marshal(&charger.APDU{MessageId: charger.MessageID_ID_RemoteControlReq, SequenceId: 2})
+	assert.Nil(t, broker.Publish(hub.Hostname+"/command/"+uuid, command))
+	err = Run(c, []Step{{Expect: func(frame []byte) error {
+		if string(frame) != expected {
+			return errors.New("unexpected frame " + string(frame))
+		}
+		return nil
+	}}}, time.Second)
+	assert.Nil(t, err)
+}
+
+func TestTCPCharger(t *testing.T) {
+	broker, hub := newHub(t)
+	codec := &tcp.DelimiterCodec{Delimiter: []byte("\n"), Strip: true}
+	c, conn := NewTCPCharger(codec)
+	defer c.Close()
+	log := &rabbitmq.Logger{Logger: zap.NewNop()}
+	client := tcp.NewClient(hub, conn, 60, "127.0.0.1", log, codec, codec)
+	client.SetChargeStation(interfaces.NewDefaultChargeStation("tcp", true, coreID))
+	run(client)
+	roundTrip(t, broker, hub, c, "command")
+}
+
+func TestWebsocketCharger(t *testing.T) {
+	broker, hub := newHub(t)
+	log := &rabbitmq.Logger{Logger: zap.NewNop()}
+	server := NewWebsocketServer(func(conn *websocket.Conn, r *http.Request) {
+		chargeStation := interfaces.NewDefaultChargeStation("ws", true, coreID)
+		run(ws.NewClient(chargeStation, hub, conn, 60, r.RemoteAddr, log))
+	})
+	defer server.Close()
+	c, err := DialWebsocket(server.URL, nil)
+	assert.Nil(t, err)
+	defer c.Close()
+	// websocket客户端把FromAPDU的结果编码为json
+	roundTrip(t, broker, hub, c, `"command"`)
+}
+
+func TestSpoolReplay(t *testing.T) {
+	broker := NewBroker()
+	s, err := spool.Open(t.TempDir(), 0, 1024)
+	assert.Nil(t, err)
+	defer s.Close()
+	hub, err := lib.NewHubWithOptions("test", "1.0", lib.WithTransport(broker.Transport("spool")), lib.WithHostname("spool"))
+	assert.Nil(t, err)
+	hub.SetSpool(s)
+	go hub.Run()
+	assert.Eventually(t, func() bool { return broker.Subscribed("spool/kick/x") }, time.Second, time.Millisecond)
+
+	disconnected := make(chan error, 1)
+	broker.Transport("observer").OnDisconnect(func(err error) {
+		disconnected <- err
+	})
+	broker.SetOnline(false)
+	assert.Equal(t, ErrOffline, <-disconnected)
+	// 发送到MQTT的消息是并发处理的, 逐条写入磁盘以便检查补发的顺序
+	for _, id := range []string{"1", "2"} {
+		size := s.Size()
+		hub.PubMqttMsg <- mqtt.MqttMessage{Topic: "coregw/spool/command/" + id, Qos: 2, Payload: []byte(id)}
+		assert.Eventually(t, func() bool { return s.Size() != size }, time.Second, time.Millisecond)
+	}
+
+	broker.SetOnline(true)
+	m, err := broker.Expect("coregw/spool/command/+", 3*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "coregw/spool/command/1", m.Topic)
+	m, err = broker.Expect("coregw/spool/command/+", 3*time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, "coregw/spool/command/2", m.Topic)
+
+	// 关闭之后发送的消息写入磁盘, 发送方不会阻塞
+	assert.Nil(t, hub.Shutdown(context.Background()))
+	size := s.Size()
+	select {
+	case hub.PubMqttMsg <- mqtt.MqttMessage{Topic: "coregw/spool/command/3", Qos: 2, Payload: []byte("3")}:
+	case <-time.After(time.Second):
+		t.Fatal("send after shutdown blocked")
+	}
+	assert.Eventually(t, func() bool { return s.Size() != size }, time.Second, time.Millisecond)
+}
+
+func TestBroker(t *testing.T) {
+	assert.True(t, Match("a/+/c", "a/b/c"))
+	assert.True(t, Match("a/#", "a/b/c"))
+	assert.False(t, Match("a/+", "a/b/c"))
+
+	broker := NewBroker()
+	received := make(chan string, 2)
+	for _, id := range []string{"gw1", "gw2"} {
+		id := id
+		broker.Client(id).Subscribe("$share/group/a/+", 2, func(_ mqttClient.Client, m mqttClient.Message) {
+			received <- id
+		})
+	}
+	assert.Nil(t, broker.Publish("a/1", nil))
+	assert.Nil(t, broker.Publish("a/2", nil))
+	// 共享订阅轮流投递给组内的客户端
+	assert.ElementsMatch(t, []string{"gw1", "gw2"}, []string{<-received, <-received})
+
+	client := broker.Client("gateway")
+	broker.SetOnline(false)
+	assert.False(t, client.IsConnectionOpen())
+	assert.Equal(t, ErrOffline, client.Publish("a/3", 2, false, []byte("x")).Error())
+	broker.SetOnline(true)
+	assert.Nil(t, client.Publish("a/3", 2, false, "x").Error())
+	m, err := broker.Expect("a/3", time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("x"), m.Payload)
+	_, err = broker.Expect("a/3", 10*time.Millisecond)
+	assert.True(t, errors.Is(err, ErrTimeout))
+}
+
+func TestDuplicateConnection(t *testing.T) {
+	broker, hub := newHub(t)
+	duplicates := make(chan lib.ClientInterface, 1)
+	hub.SetDuplicateFunc(func(old, new lib.ClientInterface) {
+		duplicates <- old
+	})
+	codec := &tcp.DelimiterCodec{Delimiter: []byte("\n"), Strip: true}
+	log := &rabbitmq.Logger{Logger: zap.NewNop()}
+	connect := func(offline func(err error)) (*TCPCharger, lib.ClientInterface) {
+		c, conn := NewTCPCharger(codec)
+		client := tcp.NewClient(hub, conn, 60, "127.0.0.1", log, codec, codec)
+		client.SetChargeStation(interfaces.NewDefaultChargeStation("tcp", true, coreID))
+		client.SetClientOfflineFunc(offline)
+		go client.ReadPump()
+		go client.WritePump()
+		go client.SubMQTT()
+		return c, client
+	}
+
+	offline := make(chan error, 1)
+	stale, old := connect(func(err error) { offline <- err })
+	defer stale.Close()
+	roundTrip(t, broker, hub, stale, "command")
+
+	// 桩在旧连接超时之前重新连接, 关闭旧连接, 新连接不受影响
+	c, client := connect(func(err error) {})
+	defer c.Close()
+	assert.True(t, errors.Is(<-offline, lib.ErrDuplicateConnection))
+	assert.Equal(t, old, <-duplicates)
+	_, err := stale.Receive(time.Second)
+	assert.NotNil(t, err)
+	current, ok := hub.Clients.Load(uint64(coreID))
+	assert.True(t, ok)
+	assert.Equal(t, client, current)
+	assert.Equal(t, uint64(1), hub.Stats().Duplicates)
+	roundTrip(t, broker, hub, c, "command")
+}
+
+func TestWebsocketAuthenticate(t *testing.T) {
+	broker, hub := newHub(t)
+	hub.SetAuthenticator(lib.AuthenticatorFunc(func(ctx context.Context, credentials *lib.Credentials) (*lib.Identity, error) {
+		if credentials.SN != "ws" || credentials.Password != "secret" {
+			return nil, errors.New("wrong password")
+		}
+		return &lib.Identity{ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, true, coreID)}, nil
+	}))
+	log := &rabbitmq.Logger{Logger: zap.NewNop()}
+	upgrader := websocket.Upgrader{Subprotocols: []string{"ocpp1.6"}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity, err := ws.Authenticate(hub, w, r, upgrader.Subprotocols...)
+		if err != nil {
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := ws.NewClient(identity.ChargeStation, hub, conn, 60, r.RemoteAddr, log)
+		identity.Apply(client)
+		run(client)
+	}))
+	defer server.Close()
+
+	dial := func(password string, subprotocol string) (*WebsocketCharger, error) {
+		header := http.Header{}
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("ws:"+password)))
+		header.Set("Sec-WebSocket-Protocol", subprotocol)
+		return DialWebsocket(server.URL+"/ocpp/ws", header)
+	}
+	_, err := dial("wrong", "ocpp1.6")
+	assert.Equal(t, websocket.ErrBadHandshake, err)
+	_, err = dial("secret", "ocpp2.0")
+	assert.Equal(t, websocket.ErrBadHandshake, err)
+	assert.Equal(t, uint64(2), hub.Stats().AuthFailures)
+
+	c, err := dial("secret", "ocpp1.6")
+	assert.Nil(t, err)
+	defer c.Close()
+	roundTrip(t, broker, hub, c, `"command"`)
+}
+
+func TestWebsocketServer(t *testing.T) {
+	broker, hub := newHub(t)
+	// 没有Authenticator时所有的桩都会被拒绝
+	_, err := ws.NewServer(hub, &rabbitmq.Logger{Logger: zap.NewNop()})
+	assert.True(t, errors.Is(err, lib.ErrNoAuthenticator))
+	negotiated := make(chan string, 3)
+	hub.SetAuthenticator(lib.AuthenticatorFunc(func(ctx context.Context, credentials *lib.Credentials) (*lib.Identity, error) {
+		negotiated <- credentials.Subprotocol
+		return &lib.Identity{ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, true, coreID)}, nil
+	}))
+	server, err := ws.NewServer(hub, &rabbitmq.Logger{Logger: zap.NewNop()}, "ocpp2.0.1", "ocpp1.6")
+	assert.Nil(t, err)
+	server.MaxMessageSizes = map[string]int64{"ocpp1.6": 16}
+	connected := make(chan string, 2)
+	server.OnConnect = func(client lib.ClientInterface, r *http.Request) {
+		connected <- client.ChargeStation().SN()
+	}
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	dial := func(subprotocol string) *WebsocketCharger {
+		c, err := DialWebsocket(s.URL+"/ocpp/"+subprotocol, http.Header{"Sec-WebSocket-Protocol": {subprotocol}})
+		assert.Nil(t, err)
+		assert.Equal(t, subprotocol, c.conn.Subprotocol())
+		assert.Equal(t, subprotocol, <-negotiated)
+		return c
+	}
+	// 桩按照自己的偏好请求时, 认证和升级都使用服务端的顺序
+	c, err := DialWebsocket(s.URL+"/ocpp/both", http.Header{"Sec-WebSocket-Protocol": {"ocpp1.6, ocpp2.0.1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, "ocpp2.0.1", c.conn.Subprotocol())
+	assert.Equal(t, "ocpp2.0.1", <-negotiated)
+	assert.Equal(t, "both", <-connected)
+	// 报文长度限制使用协商的ocpp2.0.1, 而不是桩首选的ocpp1.6
+	uuid := datasource.UUID(coreID).String()
+	assert.Eventually(t, func() bool { return broker.Subscribed(hub.Hostname + "/command/" + uuid) }, time.Second, time.Millisecond)
+	assert.Nil(t, c.Send([]byte("a heartbeat longer than the limit")))
+	m, err := broker.Expect("coregw/"+hub.Hostname+"/command/"+uuid, time.Second)
+	assert.Nil(t, err)
+	var apdu charger.APDU
+	assert.Nil(t, proto.Unmarshal(m.Payload, &apdu))
+	var payload charger.MessageError
+	assert.Nil(t, proto.Unmarshal(apdu.Payload, &payload))
+	assert.Equal(t, "a heartbeat longer than the limit", payload.Description)
+	c.Close()
+	assert.Eventually(t, func() bool { return hub.Stats().Connected == 0 }, time.Second, time.Millisecond)
+
+	c = dial("ocpp2.0.1")
+	assert.Equal(t, "ocpp2.0.1", <-connected)
+	roundTrip(t, broker, hub, c, `"command"`)
+	c.Close()
+
+	// ocpp1.6的报文最大长度为16, 超过时断开连接
+	assert.Eventually(t, func() bool { return hub.Stats().Connected == 0 }, time.Second, time.Millisecond)
+	c = dial("ocpp1.6")
+	defer c.Close()
+	assert.Equal(t, "ocpp1.6", <-connected)
+	assert.Nil(t, c.Send([]byte("a heartbeat longer than the limit")))
+	_, err = c.Receive(time.Second)
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+}
@@ -0,0 +1,62 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/gorilla/websocket"
+)
+
+// ErrSubprotocol 桩请求的子协议都不支持
+var ErrSubprotocol = errors.New("不支持的子协议")
+
+// Credentials 从升级请求中获取认证信息, sn为路径的最后一段, 用户名和密码使用HTTP Basic认证,
+// 使用TLS双向认证时包含客户端证书的序列号和CN, subprotocols不为空时按照subprotocols的顺序选择桩请求的子协议,
+// 与websocket.Upgrader协商的结果相同
+func Credentials(r *http.Request, subprotocols ...string) (*lib.Credentials, error) {
+	credentials := &lib.Credentials{
+		SN:            path.Base(r.URL.Path),
+		RemoteAddress: r.RemoteAddr,
+	}
+	credentials.Username, credentials.Password, _ = r.BasicAuth()
+	credentials.SetPeerCertificate(r.TLS)
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		if _, port, err := net.SplitHostPort(addr.String()); err == nil {
+			credentials.Port = port
+		}
+	}
+	if len(subprotocols) == 0 {
+		return credentials, nil
+	}
+	for _, subprotocol := range subprotocols {
+		for _, requested := range websocket.Subprotocols(r) {
+			if requested == subprotocol {
+				credentials.Subprotocol = subprotocol
+				return credentials, nil
+			}
+		}
+	}
+	return credentials, fmt.Errorf("%w: %v", ErrSubprotocol, websocket.Subprotocols(r))
+}
+
+// Authenticate 在升级之前使用hub的Authenticator认证桩,
+// 失败时已经回复了HTTP错误: 子协议不支持为400, 认证失败为401
+func Authenticate(hub *lib.Hub, w http.ResponseWriter, r *http.Request, subprotocols ...string) (*lib.Identity, error) {
+	credentials, err := Credentials(r, subprotocols...)
+	if err != nil {
+		hub.AddAuthFailure()
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	identity, err := hub.Authenticate(r.Context(), credentials)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="gateway"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return nil, err
+	}
+	return identity, nil
+}
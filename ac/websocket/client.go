@@ -12,6 +12,7 @@ import (
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/Kotodian/gokit/datasource"
 	"github.com/Kotodian/gokit/datasource/mqtt"
@@ -21,6 +22,7 @@ import (
 	"github.com/valyala/bytebufferpool"
 	"go.uber.org/zap"
 
+	"github.com/Kotodian/gokit/ac/capture"
 	"github.com/Kotodian/gokit/ac/lib"
 	"github.com/Kotodian/protocol/golang/hardware/charger"
 	"github.com/Kotodian/protocol/interfaces"
@@ -62,13 +64,19 @@ type Client struct {
 	coregw                  string
 	isClose                 bool
 	encryptKey              []byte
+	encryptMu               sync.RWMutex // 保护encryptKey
 	id                      string
 	certificateSN           string
 	orderInterval           int
 	baseURL                 string // 上传日志、下载固件基本地址
 	debug                   bool
+	pending                 *lib.PendingRequests // 等待桩回复的请求
+	traffic                 lib.Traffic          // 流量统计
+	maxMessageSize          int64                // 桩上传报文的最大长度
 }
 
+var _ lib.ClientMonitor = (*Client)(nil)
+
 func (c *Client) MessageNumber() int16 {
 	return 0
 }
@@ -91,8 +99,9 @@ func (c *Client) Send(msg []byte) (err error) {
 			err = e.(error)
 		}
 	}()
-	if c.hub.Encrypt != nil && len(c.encryptKey) > 0 {
-		msg, err = c.hub.Encrypt.Encode(msg, c.encryptKey)
+	c.hub.Capture(c.chargeStation.SN(), capture.KindFrameOut, "", msg)
+	if key := c.EncryptKey(); c.hub.Encrypt != nil && len(key) > 0 {
+		msg, err = c.hub.Encrypt.Encode(msg, key)
 		if err != nil {
 			return err
 		}
@@ -115,11 +124,13 @@ func (c *Client) Close(err error) error {
 			err = errors.New("平台关闭")
 		}
 		c.log.Error(err.Error(), zap.String("sn", c.chargeStation.SN()))
-		c.hub.Clients.Delete(c.chargeStation.CoreID())
-		c.hub.RegClients.Delete(c.chargeStation.CoreID())
+		c.hub.RemoveClient(c)
+		c.hub.ReleaseOwnership(c)
+		_ = c.conn.WriteControl(websocket.CloseMessage, closeMessage(err), time.Now().Add(writeWait))
 		_ = c.conn.Close()
 		c.log.Info("关闭连接", zap.String("sn", c.chargeStation.SN()))
 		c.conn = nil
+		c.pending.Expire()
 		close(c.send)
 		close(c.close)
 		close(c.mqttRegCh)
@@ -131,6 +142,21 @@ func (c *Client) Close(err error) error {
 	return nil
 }
 
+// closeMessage 关闭帧, 网关关闭时使用1001, 其他情况使用1000
+func closeMessage(err error) []byte {
+	code := websocket.CloseNormalClosure
+	if errors.Is(err, lib.ErrShutdown) {
+		code = websocket.CloseGoingAway
+	}
+	// 控制帧的payload最多125个字节, 状态码占2个字节
+	reason := err.Error()
+	for len(reason) > 123 {
+		_, size := utf8.DecodeLastRuneInString(reason)
+		reason = reason[:len(reason)-size]
+	}
+	return websocket.FormatCloseMessage(code, reason)
+}
+
 // NewClient
 // 连接客户端管理类
 func NewClient(chargeStation interfaces.ChargeStation, hub *lib.Hub, conn *websocket.Conn, keepalive int, remoteAddress string, log *rabbitmq.Logger, debug ...bool) lib.ClientInterface {
@@ -139,25 +165,32 @@ func NewClient(chargeStation interfaces.ChargeStation, hub *lib.Hub, conn *webso
 		b = debug[0]
 	}
 	return &Client{
-		log:           log,
-		chargeStation: chargeStation,
-		hub:           hub,
-		conn:          conn,
-		remoteAddress: remoteAddress,
-		send:          make(chan []byte, 5),
-		sendPing:      make(chan struct{}, 1),
-		mqttMsgCh:     make(chan mqtt.MqttMessage, 5),
-		mqttRegCh:     make(chan mqtt.MqttMessage, 5),
-		close:         make(chan struct{}),
-		keepalive:     int64(keepalive),
-		orderInterval: 30,
-		debug:         b,
+		log:            log,
+		chargeStation:  chargeStation,
+		hub:            hub,
+		conn:           conn,
+		remoteAddress:  remoteAddress,
+		send:           make(chan []byte, 5),
+		sendPing:       make(chan struct{}, 1),
+		mqttMsgCh:      make(chan mqtt.MqttMessage, 5),
+		mqttRegCh:      make(chan mqtt.MqttMessage, 5),
+		close:          make(chan struct{}),
+		keepalive:      int64(keepalive),
+		orderInterval:  30,
+		debug:          b,
+		pending:        lib.NewPendingRequests(),
+		maxMessageSize: maxMessageSize,
 	}
 }
 
+// SetMaxMessageSize 设置桩上传报文的最大长度, 需要在ReadPump之前调用
+func (c *Client) SetMaxMessageSize(size int64) {
+	c.maxMessageSize = size
+}
+
 //SubRegMQTT 监听MQTT的注册报文回复信息
 func (c *Client) SubRegMQTT() {
-	c.hub.RegClients.Store(c.chargeStation.CoreID(), c)
+	c.hub.AddRegClient(c)
 	//if c.Evse.CoreID() == 0 {
 	for {
 		c.log.Sugar().Info("------------> register msg start", c.chargeStation.SN())
@@ -182,12 +215,12 @@ func (c *Client) SubRegMQTT() {
 				trData := &lib.TRData{
 					APDU: &apdu,
 				}
-				ctx := context.WithValue(context.TODO(), "client", c)
-				ctx = context.WithValue(ctx, "trData", trData)
+				ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
+				ctx = lib.WithTRData(ctx, trData)
 
 				var msg interface{}
 				//var f lib.FromAPDUFunc
-				if msg, err = c.hub.TR.FromAPDU(ctx, &apdu); err != nil {
+				if msg, err = c.hub.FromAPDU(ctx, &apdu); err != nil {
 					err = fmt.Errorf("FromAPDU register error, err:%s topic:%s", err.Error(), topic)
 					return
 				} else if msg == nil {
@@ -214,7 +247,8 @@ func (c *Client) SubRegMQTT() {
 
 //SubMQTT 监听MQTT非注册的一般信息
 func (c *Client) SubMQTT() {
-	c.hub.Clients.Store(c.chargeStation.CoreID(), c)
+	c.hub.AddClient(c)
+	c.hub.ClaimOwnership(c)
 	// wp := workpool.New(1, 5).Start()
 	// defer wp.Stop()
 	for {
@@ -235,8 +269,8 @@ func (c *Client) SubMQTT() {
 					APDU:  &apdu,
 					Topic: topic,
 				}
-				ctx := context.WithValue(context.TODO(), "client", c)
-				ctx = context.WithValue(ctx, "trData", trData)
+				ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
+				ctx = lib.WithTRData(ctx, trData)
 				var msg interface{}
 
 				var err error
@@ -248,7 +282,7 @@ func (c *Client) SubMQTT() {
 							if apdu.MessageId != charger.MessageID_ID_MessageError {
 								apdu.MessageId = charger.MessageID_ID_MessageError
 								apdu.Payload, _ = proto.Marshal(&charger.MessageError{
-									Error:       charger.ErrorCode_EC_GenericError,
+									Error:       lib.ErrorCode(err),
 									Description: err.Error(),
 								})
 							}
@@ -259,12 +293,14 @@ func (c *Client) SubMQTT() {
 								Retained: false,
 								Payload:  apduEncoded,
 							}
+							c.hub.Capture(c.chargeStation.SN(), capture.KindMQTTOut, pubMqttMsg.Topic, pubMqttMsg.Payload)
 							c.hub.PubMqttMsg <- pubMqttMsg
 						}
 					}
 				}()
 
-				if msg, err = c.hub.TR.FromAPDU(ctx, &apdu); err != nil {
+				if msg, err = c.hub.FromAPDU(ctx, &apdu); err != nil {
+					c.hub.AddTranslateError()
 					return
 				} else if msg == nil {
 					return
@@ -282,7 +318,10 @@ func (c *Client) SubMQTT() {
 				encoder := json.NewEncoder(buffer)
 				if err = encoder.Encode(msg); err != nil {
 					return
-				} else if err = c.Send(buffer.Bytes()); err != nil {
+				}
+				c.hub.TrackCommand(c, topic, &apdu)
+				if err = c.Send(buffer.Bytes()); err != nil {
+					c.hub.UntrackCommand(c, &apdu)
 					return
 				}
 			}()
@@ -365,19 +404,24 @@ func (c *Client) ReadPump() {
 		msg = nil
 		_ = c.Close(err)
 	}()
-	c.conn.SetReadLimit(maxMessageSize)
+	dispatcher := lib.NewDispatcher(c.hub.MaxInflight)
+	defer dispatcher.Stop(lib.DispatchStopTimeout)
+	c.conn.SetReadLimit(c.maxMessageSize)
 	err = c.conn.SetReadDeadline(time.Now().Add(readWait))
 	if err != nil {
 		return
 	}
 	c.conn.SetPingHandler(func(appData string) error {
 		_ = c.conn.SetReadDeadline(time.Now().Add(readWait))
-		fmt.Printf("[%s]ping message received from %s\n", time.Now().Format("2006-01-02 15:04:05"), c.chargeStation.SN())
+		c.traffic.Received(len(appData))
+		if c.debug {
+			c.log.Info("ping message received", zap.String("sn", c.chargeStation.SN()))
+		}
 		return c.PingHandler(appData)
 	})
 
 	for {
-		ctx := context.WithValue(context.TODO(), "client", c)
+		ctx := lib.NewMessageContext(context.TODO(), c, c.log.Logger)
 		if c.conn == nil {
 			return
 		}
@@ -401,79 +445,90 @@ func (c *Client) ReadPump() {
 			break
 		}
 		msg = buffer.Bytes()
+		c.traffic.Received(len(msg))
 
 		if c.debug {
-			fmt.Printf("[%s]received message from %s\n", time.Now().Format("2006-01-02 15:04:05"), c.chargeStation.SN())
+			c.log.Info("received message", zap.String("sn", c.chargeStation.SN()), zap.Int("size", len(msg)))
 		}
 
-		if c.hub.Encrypt != nil && len(c.encryptKey) > 0 {
-			msg, err = c.hub.Encrypt.Decode(msg, c.encryptKey)
+		if key := c.EncryptKey(); c.hub.Encrypt != nil && len(key) > 0 {
+			msg, err = c.hub.Encrypt.Decode(msg, key)
 			if err != nil {
 				break
 			}
 		}
 
 		msg = bytes.TrimSpace(bytes.Replace(msg, newline, space, -1))
+		c.hub.Capture(c.chargeStation.SN(), capture.KindFrameIn, "", msg)
 
-		go func(ctx context.Context, msg []byte) {
-			trData := &lib.TRData{}
-			ctx = context.WithValue(ctx, "trData", trData)
-			var err error
-			defer func() {
-				//如果发生了错误，都回复给设备，否则发送到平台
-				if err != nil {
-					c.ReplyError(ctx, err)
-				}
-				bytebufferpool.Put(buffer)
-			}()
+		data := msg
+		dispatcher.Dispatch(func() {
+			c.handle(ctx, data, buffer)
+		})
+	}
+}
 
-			var payload proto.Message
-			defer func() {
-				if r := recover(); r != nil {
-					c.log.Sugar().Errorf("%v\n", r)
-				}
-			}()
+// handle 将桩上传的报文翻译后发送到平台
+func (c *Client) handle(ctx context.Context, msg []byte, buffer *bytebufferpool.ByteBuffer) {
+	trData := &lib.TRData{}
+	ctx = lib.WithTRData(ctx, trData)
+	var err error
+	defer func() {
+		//如果发生了错误，都回复给设备，否则发送到平台
+		if err != nil {
+			c.ReplyError(ctx, err)
+		}
+		bytebufferpool.Put(buffer)
+	}()
 
-			if payload, err = c.hub.TR.ToAPDU(ctx, msg); err != nil {
-				return
-			}
+	var payload proto.Message
+	defer func() {
+		if r := recover(); r != nil {
+			c.log.Error(fmt.Sprintf("%v", r), zap.String("sn", c.chargeStation.SN()), zap.Stack("stack"))
+		}
+	}()
 
-			if payload == nil {
-				return
-			}
+	if payload, err = c.hub.ToAPDU(ctx, msg); err != nil {
+		c.hub.AddTranslateError()
+		return
+	}
+	c.hub.ResolveCommand(c, trData.APDU)
 
-			if trData.Ignore {
-				return
-			}
+	if payload == nil {
+		return
+	}
 
-			if trData.APDU.Payload, err = proto.Marshal(payload); err != nil {
-				err = fmt.Errorf("encode cmd req payload error, err:%s", err.Error())
-				return
-			}
-			var toCoreMSG []byte
-			if toCoreMSG, err = proto.Marshal(trData.APDU); err != nil {
-				err = fmt.Errorf("encode cmd req apdu error, err:%s", err.Error())
-				return
-			}
+	if trData.Ignore {
+		return
+	}
 
-			var sendTopic string
-			var sendQos byte
-			if trData.IsTelemetry {
-				sendTopic = "coregw/" + c.hub.Hostname + "/telemetry/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			} else if !trData.Sync {
-				sendTopic = "coregw/" + c.hub.Hostname + "/command/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			} else {
-				sendTopic = c.Coregw() + "/sync/" + datasource.UUID(c.chargeStation.CoreID()).String()
-			}
-			sendQos = 2
+	if trData.APDU.Payload, err = proto.Marshal(payload); err != nil {
+		err = fmt.Errorf("encode cmd req payload error, err:%s", err.Error())
+		return
+	}
+	var toCoreMSG []byte
+	if toCoreMSG, err = proto.Marshal(trData.APDU); err != nil {
+		err = fmt.Errorf("encode cmd req apdu error, err:%s", err.Error())
+		return
+	}
 
-			c.hub.PubMqttMsg <- mqtt.MqttMessage{
-				Topic:    sendTopic,
-				Qos:      sendQos,
-				Retained: false,
-				Payload:  toCoreMSG,
-			}
-		}(ctx, msg)
+	var sendTopic string
+	var sendQos byte
+	if trData.IsTelemetry {
+		sendTopic = "coregw/" + c.hub.Hostname + "/telemetry/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	} else if !trData.Sync {
+		sendTopic = "coregw/" + c.hub.Hostname + "/command/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	} else {
+		sendTopic = c.Coregw() + "/sync/" + datasource.UUID(c.chargeStation.CoreID()).String()
+	}
+	sendQos = 2
+
+	c.hub.Capture(c.chargeStation.SN(), capture.KindMQTTOut, sendTopic, toCoreMSG)
+	c.hub.PubMqttMsg <- mqtt.MqttMessage{
+		Topic:    sendTopic,
+		Qos:      sendQos,
+		Retained: false,
+		Payload:  toCoreMSG,
 	}
 }
 
@@ -532,19 +587,23 @@ func (c *Client) WritePump() {
 				return
 			}
 			_, _ = w.Write(message)
+			sent := len(message)
 
 			// Add queued chat messages to the current websocket message.
 			n := len(c.send)
 			for i := 0; i < n; i++ {
+				queued := <-c.send
 				_, _ = w.Write(newline)
-				_, _ = w.Write(<-c.send)
+				_, _ = w.Write(queued)
+				sent += len(newline) + len(queued)
 			}
 
 			if err = w.Close(); err != nil {
 				return
 			}
+			c.traffic.Sent(sent)
 			if c.debug {
-				fmt.Printf("[%s]send message to %s\n", time.Now().Format("2006-01-02 15:04:05"), c.chargeStation.SN())
+				c.log.Info("send message", zap.String("sn", c.chargeStation.SN()), zap.Int("size", sent))
 			}
 		case <-c.sendPing:
 			if c.conn == nil {
@@ -555,17 +614,19 @@ func (c *Client) WritePump() {
 				return
 			}
 			if c.debug {
-				fmt.Printf("[%s]send message to %s\n", time.Now().Format("2006-01-02 15:04:05"), c.chargeStation.SN())
+				c.log.Info("send ping message", zap.String("sn", c.chargeStation.SN()))
 			}
 		}
 	}
 }
 
 func (c *Client) PublishReg(m mqtt.MqttMessage) {
+	c.hub.Capture(c.chargeStation.SN(), capture.KindMQTTIn, m.Topic, m.Payload)
 	c.mqttRegCh <- m
 }
 
 func (c *Client) Publish(m mqtt.MqttMessage) {
+	c.hub.Capture(c.chargeStation.SN(), capture.KindMQTTIn, m.Topic, m.Payload)
 	c.mqttMsgCh <- m
 }
 
@@ -614,6 +675,8 @@ func (c *Client) SetCoregw(coregw string) {
 }
 
 func (c *Client) SetEncryptKey(encryptKey string) {
+	c.encryptMu.Lock()
+	defer c.encryptMu.Unlock()
 	c.encryptKey = []byte(encryptKey)
 }
 
@@ -622,6 +685,8 @@ func (c *Client) IsClose() bool {
 }
 
 func (c *Client) EncryptKey() []byte {
+	c.encryptMu.RLock()
+	defer c.encryptMu.RUnlock()
 	return c.encryptKey
 }
 
@@ -640,6 +705,7 @@ func (c *Client) PingHandler(msg string) error {
 	if err != nil {
 		c.log.Error(err.Error(), zap.String("sn", c.chargeStation.SN()))
 	}
+	c.hub.RefreshOwnership(c)
 	return nil
 }
 
@@ -678,3 +744,20 @@ func (c *Client) BaseURL() string {
 func (c *Client) SetBaseURL(baseURL string) {
 	c.baseURL = baseURL
 }
+
+func (c *Client) Pending() *lib.PendingRequests {
+	return c.pending
+}
+
+func (c *Client) Stats() lib.ClientStats {
+	stats := lib.ClientStats{
+		SN:            c.chargeStation.SN(),
+		CoreID:        c.chargeStation.CoreID(),
+		RemoteAddress: c.remoteAddress,
+		SendQueue:     len(c.send),
+		MqttQueue:     len(c.mqttMsgCh),
+		Pending:       c.pending.Len(),
+	}
+	c.traffic.Fill(&stats)
+	return stats
+}
@@ -0,0 +1,119 @@
+package websocket
+
+import (
+	"crypto/tls"
+	"net/http"
+	"sync"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/gorilla/websocket"
+)
+
+// Server 处理桩的websocket连接: 协商子协议, 认证, 升级后创建Client并启动
+type Server struct {
+	Hub *lib.Hub
+	Log *rabbitmq.Logger
+	// Subprotocols 支持的子协议, 例如ocpp1.6, ocpp2.0.1, 为空时不检查
+	Subprotocols []string
+	// KeepAlive 认证结果没有心跳时间时使用
+	KeepAlive int
+	// MaxMessageSize 桩上传报文的最大长度, 为0时为4096
+	MaxMessageSize int64
+	// MaxMessageSizes 按子协议设置报文的最大长度, 优先于MaxMessageSize
+	MaxMessageSizes map[string]int64
+	// EnableCompression 是否协商permessage-deflate压缩
+	EnableCompression bool
+	// CheckOrigin 为空时只检查请求中的Origin与Host是否一致
+	CheckOrigin func(r *http.Request) bool
+	// OnConnect 客户端启动之前调用, 可以设置离线通知等, 默认的离线通知不做任何处理
+	OnConnect func(client lib.ClientInterface, r *http.Request)
+	// Debug 打印收到的报文
+	Debug bool
+	// TLSConfig 不为空时ListenAndServe使用TLS, 可以使用certs.ServerConfig开启双向认证
+	TLSConfig *tls.Config
+
+	mu     sync.Mutex
+	server *http.Server
+}
+
+var _ http.Handler = (*Server)(nil)
+
+// NewServer 使用hub的Authenticator认证桩, 没有设置Authenticator时所有的桩都无法连接, 返回lib.ErrNoAuthenticator
+func NewServer(hub *lib.Hub, log *rabbitmq.Logger, subprotocols ...string) (*Server, error) {
+	if hub.Authenticator == nil {
+		return nil, lib.ErrNoAuthenticator
+	}
+	return &Server{Hub: hub, Log: log, Subprotocols: subprotocols}, nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+		return
+	}
+	identity, err := Authenticate(s.Hub, w, r, s.Subprotocols...)
+	if err != nil {
+		s.Log.Sugar().Infof("reject %s from %s, err:%s", r.URL.Path, r.RemoteAddr, err.Error())
+		return
+	}
+	upgrader := websocket.Upgrader{
+		ReadBufferSize:    readBufferSize,
+		Subprotocols:      s.Subprotocols,
+		EnableCompression: s.EnableCompression,
+		CheckOrigin:       s.CheckOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade已经回复了HTTP错误
+		return
+	}
+	client := NewClient(identity.ChargeStation, s.Hub, conn, s.KeepAlive, r.RemoteAddr, s.Log, s.Debug).(*Client)
+	identity.Apply(client)
+	client.SetMaxMessageSize(s.maxMessageSize(conn.Subprotocol()))
+	client.SetClientOfflineFunc(func(err error) {})
+	if s.OnConnect != nil {
+		s.OnConnect(client, r)
+	}
+	go client.ReadPump()
+	go client.WritePump()
+	// 未注册的桩先等待平台的注册报文
+	if identity.ChargeStation.Registered() {
+		go client.SubMQTT()
+	} else {
+		go client.SubRegMQTT()
+	}
+}
+
+// ListenAndServe 监听addr并处理连接, TLSConfig不为空时使用TLS
+func (s *Server) ListenAndServe(addr string) error {
+	server := &http.Server{Addr: addr, Handler: s, TLSConfig: s.TLSConfig}
+	s.mu.Lock()
+	s.server = server
+	s.mu.Unlock()
+	if s.TLSConfig != nil {
+		// 证书由TLSConfig提供
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// Close 停止ListenAndServe, 已经建立的连接由Hub.Shutdown关闭
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Close()
+}
+
+func (s *Server) maxMessageSize(subprotocol string) int64 {
+	if size, ok := s.MaxMessageSizes[subprotocol]; ok && size > 0 {
+		return size
+	}
+	if s.MaxMessageSize > 0 {
+		return s.MaxMessageSize
+	}
+	return maxMessageSize
+}
@@ -0,0 +1,105 @@
+package websocket
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Kotodian/gokit/ac/lib"
+	"github.com/Kotodian/gokit/datasource/rabbitmq"
+	"github.com/Kotodian/protocol/interfaces"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestServer(t *testing.T) {
+	hub := &lib.Hub{}
+	log := &rabbitmq.Logger{Logger: zap.NewNop()}
+	_, err := NewServer(hub, log)
+	assert.True(t, errors.Is(err, lib.ErrNoAuthenticator))
+
+	negotiated := make(chan string, 1)
+	hub.SetAuthenticator(lib.AuthenticatorFunc(func(ctx context.Context, credentials *lib.Credentials) (*lib.Identity, error) {
+		if credentials.Password != "secret" {
+			return nil, lib.ErrAuthentication
+		}
+		negotiated <- credentials.Subprotocol
+		return &lib.Identity{ChargeStation: interfaces.NewDefaultChargeStation(credentials.SN, false, 0)}, nil
+	}))
+	server, err := NewServer(hub, log, "ocpp2.0.1", "ocpp1.6")
+	assert.Nil(t, err)
+	server.MaxMessageSizes = map[string]int64{"ocpp1.6": 16}
+	connected := make(chan *Client, 1)
+	server.OnConnect = func(client lib.ClientInterface, r *http.Request) {
+		connected <- client.(*Client)
+	}
+	s := httptest.NewServer(server)
+	defer s.Close()
+
+	dial := func(password string, subprotocols string) (*websocket.Conn, int, error) {
+		header := http.Header{
+			"Sec-WebSocket-Protocol": {subprotocols},
+			"Authorization":          {"Basic " + base64.StdEncoding.EncodeToString([]byte("sn:"+password))},
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ocpp/sn", header)
+		if resp == nil {
+			return conn, 0, err
+		}
+		return conn, resp.StatusCode, err
+	}
+
+	// 不是websocket升级请求
+	resp, err := http.Get(s.URL + "/ocpp/sn")
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// 子协议都不支持或者认证失败时在升级之前拒绝
+	_, status, err := dial("secret", "ocpp2.0")
+	assert.Equal(t, websocket.ErrBadHandshake, err)
+	assert.Equal(t, http.StatusBadRequest, status)
+	_, status, err = dial("wrong", "ocpp1.6")
+	assert.Equal(t, websocket.ErrBadHandshake, err)
+	assert.Equal(t, http.StatusUnauthorized, status)
+	assert.Equal(t, uint64(2), hub.Stats().AuthFailures)
+
+	// 按照服务端的顺序选择子协议, 没有单独设置时使用默认的报文长度
+	conn, _, err := dial("secret", "ocpp1.6, ocpp2.0.1")
+	assert.Nil(t, err)
+	assert.Equal(t, "ocpp2.0.1", conn.Subprotocol())
+	select {
+	case subprotocol := <-negotiated:
+		assert.Equal(t, "ocpp2.0.1", subprotocol)
+	case <-time.After(time.Second):
+		t.Fatal("not authenticated")
+	}
+	select {
+	case client := <-connected:
+		assert.Equal(t, int64(maxMessageSize), client.maxMessageSize)
+	case <-time.After(time.Second):
+		t.Fatal("not connected")
+	}
+	conn.Close()
+
+	// ocpp1.6的报文超过16字节时断开连接
+	conn, _, err = dial("secret", "ocpp1.6")
+	assert.Nil(t, err)
+	defer conn.Close()
+	assert.Equal(t, "ocpp1.6", conn.Subprotocol())
+	select {
+	case client := <-connected:
+		assert.Equal(t, int64(16), client.maxMessageSize)
+	case <-time.After(time.Second):
+		t.Fatal("not connected")
+	}
+	assert.Nil(t, conn.WriteMessage(websocket.TextMessage, []byte("a heartbeat longer than the limit")))
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseMessageTooBig))
+}
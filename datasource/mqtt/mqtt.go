@@ -51,6 +51,13 @@ func NewMQTTClient(mqttOpts *mqtt.ClientOptions) *MQTTClient {
 	}
 }
 
+// WrapMQTTClient 使用已有的连接, 例如测试时使用的内存broker
+func WrapMQTTClient(client mqtt.Client) *MQTTClient {
+	return &MQTTClient{
+		mqtt: client,
+	}
+}
+
 func NewMQTTOptions(clientID string, username string, password string, onConn mqtt.OnConnectHandler, onLostConn mqtt.ConnectionLostHandler, onMsg mqtt.MessageHandler, clean bool) *mqtt.ClientOptions {
 	mqttOpts := mqtt.NewClientOptions()
 	mqttOpts.AddBroker(os.Getenv(EnvEmqxPool))